@@ -17,6 +17,8 @@ import (
 	"github.com/benoitkugler/structgen/orm/composites"
 	"github.com/benoitkugler/structgen/orm/creation"
 	"github.com/benoitkugler/structgen/orm/crud"
+	"github.com/benoitkugler/structgen/orm/jsonsql"
+	"github.com/benoitkugler/structgen/orm/seed"
 	tstypes "github.com/benoitkugler/structgen/ts-types"
 )
 
@@ -52,7 +54,13 @@ var fmts formatter.Formatters
 func main() {
 	source := flag.String("source", "", "go source file to convert")
 	var modes Modes
-	flag.Var(&modes, "mode", "list of modes <mode>:<output>")
+	flag.Var(&modes, "mode", "list of modes <mode>:<output> (rand_rapid requires pgregory.net/rapid v0.4.x)")
+	indexForeignKeys := flag.Bool("sql-index-fk", false, "sql_gen mode: add an index on every foreign key")
+	withContext := flag.Bool("sql-context", false, "sql, sql_test and sql_seed modes: add a context.Context argument and query hooks")
+	outbox := flag.Bool("sql-outbox", false, "sql and sql_gen modes: record the changes in an outbox table")
+	nativeEnums := flag.Bool("sql-native-enums", false, "sql_gen mode: use PostgreSQL enum types for string enums")
+	incrementalJSON := flag.Bool("sql-incremental-json", false, "sql_gen mode: name the JSON validation functions after their content (see the sql_upgrade mode)")
+	explainJSON := flag.Bool("sql-explain-json", false, "sql_gen mode: add functions explaining why a JSON value is invalid")
 
 	flag.Parse()
 	if source == nil || *source == "" {
@@ -93,23 +101,52 @@ func main() {
 		case "itfs-json":
 			typeHandler = interfaces.NewHandler(packageName)
 			format = formatter.Go
+		case "itfs-json_test":
+			// the tests use the rand mode functions : they must be generated in a test file as well
+			typeHandler = interfaces.NewTestHandler(packageName)
+			format = formatter.Go
 		case "rand":
 			typeHandler = data.NewHandler(packageName, en)
 			format = formatter.Go
+		case "rand_quick":
+			// the Generate methods call the rand<Type>From functions of the rand mode
+			typeHandler = data.NewQuickHandler(packageName, en)
+			format = formatter.Go
+		case "rand_rapid":
+			// the generators use the pre-generics API : the package must require pgregory.net/rapid v0.4.x
+			typeHandler = data.NewRapidHandler(packageName, en)
+			format = formatter.Go
 		case "sql":
-			typeHandler = crud.NewHandler(packageName, false)
+			typeHandler = crud.NewHandler(packageName, crud.Options{WithContext: *withContext, Outbox: *outbox})
 			format = formatter.Go
 		case "sql_test":
-			typeHandler = crud.NewHandler(packageName, true)
+			typeHandler = crud.NewHandler(packageName, crud.Options{IsTest: true, WithContext: *withContext})
 			format = formatter.Go
 		case "sql_gen":
-			// do not emit instruction to remove existing declarations
-			const eraseJSONDecl = false
-			typeHandler = creation.NewGenHandler(en, eraseJSONDecl)
+			typeHandler = creation.NewGenHandler(en, creation.Options{
+				// do not emit instruction to remove existing declarations
+				EraseJSONDecl:    false,
+				IndexForeignKeys: *indexForeignKeys,
+				Outbox:           *outbox,
+				NativeEnums:      *nativeEnums,
+				IncrementalJSON:  *incrementalJSON,
+				ExplainJSON:      *explainJSON,
+			})
+			format = formatter.Psql
+		case "sql_upgrade":
+			// upgrades the JSON validations of a database created with -sql-incremental-json
+			typeHandler = creation.NewUpgradeHandler(en, creation.Options{ExplainJSON: *explainJSON})
 			format = formatter.Psql
 		case "sql_composite":
 			typeHandler = &composites.Composites{OriginPackageName: packageName}
 			format = formatter.Go
+		case "sql_seed":
+			// the seeder uses the rand mode functions : both must be generated in the same, non test, package
+			typeHandler = seed.NewHandler(packageName, *withContext)
+			format = formatter.Go
+		case "json_validate":
+			typeHandler = jsonsql.NewGoHandler(packageName, en)
+			format = formatter.Go
 		case "enums":
 			typeHandler = enums.Handler{PackageName: packageName, Enums: en}
 			format = formatter.Go
@@ -0,0 +1,396 @@
+package data
+
+import (
+	"fmt"
+	"go/types"
+	"hash/fnv"
+	"log"
+	"math"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/benoitkugler/structgen/loader"
+	"github.com/benoitkugler/structgen/orm"
+	"github.com/benoitkugler/structgen/orm/jsonsql"
+)
+
+// check is a simple comparison of a CHECK constraint, on the
+// value or on the length of a column.
+type check struct {
+	column   string
+	isLength bool
+	op       string
+	value    float64
+	nonEmpty bool // column <> ''
+}
+
+var (
+	reCheck   = regexp.MustCompile(`(?is)CHECK\s*\((.*)\)`)
+	reBetween = regexp.MustCompile(`(?i)(\w+\s*\(\s*\w+\s*\)|\w+)\s+BETWEEN\s+(-?[\d.]+)\s+AND\s+(-?[\d.]+)`)
+	reAnd     = regexp.MustCompile(`(?i)\s+AND\s+`)
+	reCompare = regexp.MustCompile(`^(\w+)\s*(>=|<=|<>|!=|>|<|=)\s*(-?[\d.]+)$`)
+	reLength  = regexp.MustCompile(`(?i)^(?:char_length|length)\s*\(\s*(\w+)\s*\)\s*(>=|<=|>|<|=)\s*(\d+)$`)
+	reEmpty   = regexp.MustCompile(`^(\w+)\s*(?:<>|!=)\s*''$`)
+)
+
+// parseChecks extracts the comparisons of a CHECK constraint
+// such as CHECK(total >= 0 AND char_length(code) <= 12).
+// The other conditions are ignored.
+func parseChecks(constraint string) (out []check) {
+	match := reCheck.FindStringSubmatch(constraint)
+	if match == nil {
+		return nil
+	}
+	cond := reBetween.ReplaceAllString(match[1], "$1 >= $2 AND $1 <= $3")
+	for _, part := range reAnd.Split(cond, -1) {
+		part = strings.TrimSpace(strings.Trim(strings.TrimSpace(part), "()"))
+		if m := reEmpty.FindStringSubmatch(part); m != nil {
+			out = append(out, check{column: m[1], nonEmpty: true})
+		} else if m := reLength.FindStringSubmatch(part); m != nil {
+			v, _ := strconv.ParseFloat(m[3], 64)
+			out = append(out, check{column: m[1], isLength: true, op: m[2], value: v})
+		} else if m := reCompare.FindStringSubmatch(part); m != nil {
+			v, err := strconv.ParseFloat(m[3], 64)
+			if err == nil {
+				out = append(out, check{column: m[1], op: m[2], value: v})
+			}
+		}
+	}
+	return out
+}
+
+// apply restricts `l` for a column of numbers (possibly integers) or with a length.
+func (c check) apply(l *jsonsql.Limits, isNumber, isInteger bool) {
+	switch {
+	case c.nonEmpty:
+		l.RestrictLen(">=", 1)
+	case c.isLength:
+		l.RestrictLen(c.op, int(c.value))
+	case !isNumber:
+	case c.op == "<>" || c.op == "!=":
+		if c.value == 0 {
+			l.NonZero = true
+		}
+	case c.op == "=":
+		l.Restrict("min", c.value)
+		l.Restrict("max", c.value)
+	case c.op == ">=" || c.op == ">":
+		v := c.value
+		if c.op == ">" {
+			v = strictBound(v, isInteger, math.Inf(1))
+		}
+		l.Restrict("min", v)
+	case c.op == "<=" || c.op == "<":
+		v := c.value
+		if c.op == "<" {
+			v = strictBound(v, isInteger, math.Inf(-1))
+		}
+		l.Restrict("max", v)
+	}
+}
+
+func strictBound(v float64, isInteger bool, direction float64) float64 {
+	if isInteger {
+		return math.Floor(v) + math.Copysign(1, direction)
+	}
+	return math.Nextafter(v, direction)
+}
+
+// valueKind returns the kind of values of `typ` : numbers,
+// integers, or values with a length
+func valueKind(typ types.Type) (isNumber, isInteger, hasLength bool) {
+	switch under := typ.Underlying().(type) {
+	case *types.Basic:
+		info := under.Info()
+		return info&types.IsNumeric != 0, info&types.IsInteger != 0, info&types.IsString != 0
+	case *types.Slice, *types.Map:
+		return false, false, true
+	}
+	return false, false, false
+}
+
+// fieldLimits returns the limits of the field, defined by its validate,
+// sql_len and sql_type tags, and by the CHECK constraints of its table.
+// It returns false if the values are not restricted.
+func fieldLimits(field *types.Var, tag string, checks []check) (jsonsql.Limits, bool) {
+	isNumber, isInteger, hasLength := valueKind(field.Type())
+	if !isNumber && !hasLength {
+		return jsonsql.NoLimits, false
+	}
+	st := reflect.StructTag(tag)
+	out := jsonsql.NoLimits
+	if validate := st.Get("validate"); validate != "" {
+		var err error
+		out, err = jsonsql.ParseLimits(validate, isNumber)
+		if err != nil {
+			log.Printf("warning : %s, validate tag ignored for random values", err)
+		}
+	}
+	if sqlLen := st.Get("sql_len"); sqlLen != "" {
+		chunks := strings.Split(sqlLen, ",")
+		precision, _ := strconv.Atoi(chunks[0])
+		if isNumber && precision > 0 {
+			scale := 0
+			if len(chunks) == 2 {
+				scale, _ = strconv.Atoi(chunks[1])
+			}
+			bound := math.Pow10(precision-scale) - math.Pow10(-scale)
+			out.Restrict("min", -bound)
+			out.Restrict("max", bound)
+		} else if precision > 0 {
+			out.RestrictLen("<=", precision)
+		}
+	}
+	switch sqlType := strings.ToLower(st.Get("sql_type")); {
+	case sqlType == "smallint" && isNumber:
+		out.Restrict("min", math.MinInt16)
+		out.Restrict("max", math.MaxInt16)
+	case sqlType == "integer" && isNumber:
+		out.Restrict("min", math.MinInt32)
+		out.Restrict("max", math.MaxInt32)
+	case strings.HasPrefix(sqlType, "varchar(") || strings.HasPrefix(sqlType, "char("):
+		n, _ := strconv.Atoi(strings.TrimSuffix(sqlType[strings.IndexByte(sqlType, '(')+1:], ")"))
+		out.RestrictLen("<=", n)
+	}
+	column := orm.ColumnName(field, tag)
+	for _, c := range checks {
+		if c.column == column {
+			c.apply(&out, isNumber, isInteger)
+		}
+	}
+	return out, !reflect.DeepEqual(out, jsonsql.NoLimits)
+}
+
+// fnConstrained generates values respecting limits,
+// and frequently returns edge cases (bounds, empty values, unicode).
+type fnConstrained struct {
+	Elem          dataFunction
+	Limits        jsonsql.Limits
+	TargetPackage string
+}
+
+func (f fnConstrained) Id() string {
+	h := fnv.New32a()
+	fmt.Fprint(h, f.Limits.MinLen, f.Limits.MaxLen, f.Limits.NonZero, f.Limits.Format, f.Limits.Pattern)
+	if f.Limits.Min != nil {
+		fmt.Fprint(h, "min", *f.Limits.Min)
+	}
+	if f.Limits.Max != nil {
+		fmt.Fprint(h, "max", *f.Limits.Max)
+	}
+	return fmt.Sprintf("%s_%08x", f.Elem.Id(), h.Sum32())
+}
+
+func (f fnConstrained) Type() types.Type { return f.Elem.Type() }
+
+// integerRange returns the bounds of the basic integer `kind`,
+// restricted to keep the values readable.
+func integerRange(kind types.BasicKind) (float64, float64) {
+	const limit = 1e15
+	switch kind {
+	case types.Int8:
+		return math.MinInt8, math.MaxInt8
+	case types.Int16:
+		return math.MinInt16, math.MaxInt16
+	case types.Int32:
+		return math.MinInt32, math.MaxInt32
+	case types.Uint8:
+		return 0, math.MaxUint8
+	case types.Uint16:
+		return 0, math.MaxUint16
+	case types.Uint32:
+		return 0, math.MaxUint32
+	case types.Uint, types.Uint64, types.Uintptr:
+		return 0, limit
+	default:
+		return -limit, limit
+	}
+}
+
+// numberRange returns the bounds of the generated numbers, defaulting
+// to the range of the unconstrained values, [0, 1e6).
+func (f fnConstrained) numberRange(minType, maxType float64) (lo, hi float64) {
+	lo, hi = 0, 999999
+	if f.Limits.Min != nil {
+		lo = *f.Limits.Min
+		hi = lo + 999999
+	}
+	if f.Limits.Max != nil {
+		hi = *f.Limits.Max
+		if f.Limits.Min == nil {
+			lo = math.Min(0, hi-999999)
+		}
+	}
+	return math.Max(lo, minType), math.Min(hi, maxType)
+}
+
+func (f fnConstrained) Render() []loader.Declaration {
+	decls := f.Elem.Render()
+	tn := typeName(f.TargetPackage, f.Type())
+
+	var body string
+	switch under := f.Type().Underlying().(type) {
+	case *types.Basic:
+		info := under.Info()
+		switch {
+		case info&types.IsInteger != 0:
+			lo, hi := f.numberRange(integerRange(under.Kind()))
+			lo, hi = math.Ceil(lo), math.Floor(hi)
+			if lo > hi {
+				log.Printf("warning : empty range [%v, %v] for %s", lo, hi, tn)
+				hi = lo
+			}
+			body = fmt.Sprintf(`var v int64
+		switch r.Intn(8) { // edge cases
+		case 0:
+			v = %[1]d
+		case 1:
+			v = %[2]d
+		default:
+			v = %[1]d + r.Int63n(%[3]d)
+		}`, int64(lo), int64(hi), int64(hi-lo)+1)
+			if f.Limits.NonZero {
+				nonZero := int64(hi)
+				if nonZero == 0 {
+					nonZero = int64(lo)
+				}
+				body += fmt.Sprintf(`
+		if v == 0 {
+			v = %d
+		}`, nonZero)
+			}
+			body += fmt.Sprintf("\nreturn %s(v)", tn)
+		case info&types.IsFloat != 0:
+			lo, hi := f.numberRange(-math.MaxFloat64, math.MaxFloat64)
+			body = fmt.Sprintf(`var v float64
+		switch r.Intn(8) { // edge cases
+		case 0:
+			v = %[1]s
+		case 1:
+			v = %[2]s
+		default:
+			v = %[1]s + r.Float64()*(%[2]s-%[1]s)
+		}`, strconv.FormatFloat(lo, 'g', -1, 64), strconv.FormatFloat(hi, 'g', -1, 64))
+			if f.Limits.NonZero {
+				body += fmt.Sprintf(`
+		if v == 0 {
+			v = %s
+		}`, strconv.FormatFloat(hi, 'g', -1, 64))
+			}
+			body += fmt.Sprintf("\nreturn %s(v)", tn)
+		case info&types.IsString != 0:
+			decls = append(decls, fnStringHelpers())
+			body = f.renderString(tn)
+		}
+	case *types.Slice:
+		if elem, ok := collectionElem(f.Elem); ok {
+			decls = append(decls, elem[0].Render()...)
+			minLen, maxLen := f.lengthRange()
+			body = fmt.Sprintf(`l := %d + r.Intn(%d)
+		out := make(%s, l)
+		for i := range out {
+			out[i] = rand%sFrom(r)
+		}
+		return out`, minLen, maxLen-minLen+1, tn, elem[0].Id())
+		}
+	case *types.Map:
+		if elems, ok := collectionElem(f.Elem); ok {
+			decls = append(decls, elems[0].Render()...)
+			decls = append(decls, elems[1].Render()...)
+			minLen, maxLen := f.lengthRange()
+			body = fmt.Sprintf(`l := %d + r.Intn(%d)
+		out := make(%s, l)
+		for attempt := 0; len(out) < l && attempt < 10*l; attempt++ {
+			out[rand%sFrom(r)] = rand%sFrom(r)
+		}
+		return out`, minLen, maxLen-minLen+1, tn, elems[0].Id(), elems[1].Id())
+		}
+	}
+	if body == "" { // not supported : use the unconstrained values
+		log.Printf("warning : validation constraints not supported for random %s", tn)
+		body = fmt.Sprintf("return rand%sFrom(r)", f.Elem.Id())
+	}
+	return append(decls, loader.Declaration{Id: f.Id(), Content: randFunction(f.Id(), tn, body)})
+}
+
+// lengthRange returns the bounds of the length of the values,
+// defaulting to 50 runes for strings and 40 to 49 elements otherwise.
+func (f fnConstrained) lengthRange() (int, int) {
+	minLen, maxLen := f.Limits.MinLen, f.Limits.MaxLen
+	if maxLen == -1 {
+		maxLen = minLen + 50
+	}
+	if minLen > maxLen {
+		log.Printf("warning : empty length range [%d, %d]", minLen, maxLen)
+		maxLen = minLen
+	}
+	return minLen, maxLen
+}
+
+// formatLengths returns the range of the number of random runes of the
+// email and url values, so that they respect the length limits. The values
+// have `fixed` other runes, and at least `minRandom` random runes.
+func (f fnConstrained) formatLengths(fixed, minRandom int) (int, int) {
+	lo, hi := f.Limits.MinLen-fixed, 20
+	if lo < minRandom {
+		lo = minRandom
+	}
+	if f.Limits.MaxLen != -1 {
+		hi = f.Limits.MaxLen - fixed
+	} else if hi < lo {
+		hi = lo
+	}
+	if lo > hi {
+		panic(fmt.Sprintf("no %s value with a length in [%d, %d]", f.Limits.Format, f.Limits.MinLen, f.Limits.MaxLen))
+	}
+	return lo, hi
+}
+
+func (f fnConstrained) renderString(tn string) string {
+	switch f.Limits.Format {
+	case "email":
+		lo, hi := f.formatLengths(len("@.com"), 2)
+		return fmt.Sprintf(`n := %d + r.Intn(%d)
+		user := 1 + r.Intn(n-1)
+		return %s(randRunesFrom(r, asciiRunes, user) + "@" + randRunesFrom(r, asciiRunes, n-user) + ".com")`, lo, hi-lo+1, tn)
+	case "url":
+		lo, hi := f.formatLengths(len("https://.com/"), 1)
+		return fmt.Sprintf(`n := %d + r.Intn(%d)
+		host := 1 + r.Intn(n)
+		return %s("https://" + randRunesFrom(r, asciiRunes, host) + ".com/" + randRunesFrom(r, asciiRunes, n-host))`, lo, hi-lo+1, tn)
+	}
+	if f.Limits.Pattern != "" {
+		// values matching the pattern are built now, and picked at random
+		values, err := matchingValues(f.Limits.Pattern, f.Limits.MinLen, f.Limits.MaxLen)
+		if err != nil {
+			panic(fmt.Sprintf("random values for pattern %s: %s", f.Limits.Pattern, err))
+		}
+		return fmt.Sprintf("return %s(%#v[r.Intn(%d)])", tn, values, len(values))
+	}
+	minLen, maxLen := f.lengthRange()
+	return fmt.Sprintf(`switch r.Intn(8) { // edge cases
+		case 0:
+			return %[1]s(randRunesFrom(r, letterRunes2, %[2]d)) // shortest, empty if allowed
+		case 1:
+			return %[1]s(randRunesFrom(r, letterRunes2, %[3]d)) // longest
+		case 2:
+			return %[1]s(randRunesFrom(r, unicodeRunes, %[2]d+r.Intn(%[4]d)))
+		}
+		return %[1]s(randRunesFrom(r, letterRunes2, %[2]d+r.Intn(%[4]d)))`, tn, minLen, maxLen, maxLen-minLen+1)
+}
+
+// collectionElem returns the functions generating the
+// elements of a slice, or the keys and elements of a map.
+func collectionElem(fn dataFunction) ([]dataFunction, bool) {
+	switch fn := fn.(type) {
+	case fnSlice:
+		return []dataFunction{fn.Elem}, true
+	case fnMap:
+		return []dataFunction{fn.Key, fn.Elem}, true
+	case fnNamed:
+		return collectionElem(fn.Underlying)
+	}
+	return nil, false
+}
@@ -19,6 +19,9 @@ type dataFunction interface {
 	Id() string
 	Type() types.Type
 	Render() []loader.Declaration
+	// Rapid returns the declarations of the
+	// gen<Id> function, returning a rapid generator
+	Rapid() []loader.Declaration
 }
 
 func typeName(target string, typ types.Type) string {
@@ -59,47 +62,65 @@ func (f FnBasic) Render() []loader.Declaration {
 	case types.Float64:
 		code = fnFloat64()
 	case types.String:
-		code = fnString()
+		return []loader.Declaration{fnStringHelpers(), {Id: f.Id(), Content: fnString()}}
 	default:
 		panic(fmt.Sprintf("basic type %v not supported", f.type_))
 	}
 	return []loader.Declaration{{Id: f.Id(), Content: code}}
 }
 
+// randFunction returns the code of the function building a random
+// value of type `typ` from the source `r`, named rand<id>From, and of
+// its convenience wrapper rand<id>, using the global source.
+func randFunction(id, typ, body string) string {
+	return fmt.Sprintf(`
+	func rand%[1]sFrom(r *rand.Rand) %[2]s {
+		%[3]s
+	}
+
+	func rand%[1]s() %[2]s { return rand%[1]sFrom(globalRand) }`, id, typ, body)
+}
+
 func fnBool() string {
-	return `
-	func randbool() bool {
-		i := rand.Int31n(2)
-		return i == 1
-	}`
+	return randFunction("bool", "bool", `i := r.Int31n(2)
+		return i == 1`)
 }
 
 func fnInt(intType string) string {
-	return fmt.Sprintf(`
-	func rand%s() %s {
-		return %s(rand.Intn(1000000))
-	}`, intType, intType, intType)
+	return randFunction(intType, intType, fmt.Sprintf("return %s(r.Intn(1000000))", intType))
 }
 
 func fnFloat64() string {
-	return `
-	func randfloat64() float64 {
-		return rand.Float64() * float64(rand.Int31())
-	}`
+	return randFunction("float64", "float64", "return r.Float64() * float64(r.Int31())")
 }
 
-func fnString() string {
-	return `
-	var letterRunes2  = []rune("azertyuiopqsdfghjklmwxcvbn123456789é@!?&èïab ")
+// fnStringHelpers returns the alphabets of the
+// random strings and the function using them.
+func fnStringHelpers() loader.Declaration {
+	return loader.Declaration{Id: "stringHelpers", Content: `
+	var (
+		letterRunes2 = []rune("azertyuiopqsdfghjklmwxcvbn123456789é@!?&èïab ")
+		asciiRunes   = []rune("abcdefghijklmnopqrstuvwxyz0123456789")
+		unicodeRunes = []rune("éàçßøΩπжд漢字日本語🙂🎉")
+	)
 
-	func randstring() string {
-		b := make([]rune, 50)
-		maxLength := len(letterRunes2)		
+	// randRunesFrom returns a string of 'length' runes taken from 'alphabet'
+	func randRunesFrom(r *rand.Rand, alphabet []rune, length int) string {
+		b := make([]rune, length)
 		for i := range b {
-			b[i] = letterRunes2[rand.Intn(maxLength)]
+			b[i] = alphabet[r.Intn(len(alphabet))]
 		}
 		return string(b)
-	}`
+	}`}
+}
+
+// fnString never returns the empty string, which may be rejected by
+// constraints not known here : see fnConstrained for the edge cases.
+func fnString() string {
+	return randFunction("string", "string", `if r.Intn(16) == 0 { // edge case
+			return randRunesFrom(r, unicodeRunes, 50)
+		}
+		return randRunesFrom(r, letterRunes2, 50)`)
 }
 
 type fnTime struct {
@@ -111,15 +132,47 @@ func (f fnTime) Id() string {
 }
 
 func (f fnTime) Type() types.Type {
-	return types.NewNamed(types.NewTypeName(0, nil, "Time", nil), &types.Struct{}, nil)
+	return types.NewNamed(types.NewTypeName(0, types.NewPackage("time", "time"), "Time", nil), &types.Struct{}, nil)
 }
 
 func (f fnTime) Render() []loader.Declaration {
-	return []loader.Declaration{{Id: f.Id(), Content: `
-	func randtTime() time.Time {
-		return time.Unix(int64(rand.Int31()), 5)
-	}
-	`}}
+	// times are stored as timestamp (0), that is with whole seconds,
+	// and UTC times are preserved by a JSON round trip.
+	// The zero time is not generated, since it may be rejected by constraints not known here.
+	return []loader.Declaration{{Id: f.Id(), Content: randFunction(f.Id(), "time.Time", `return time.Unix(1+int64(r.Int31()), 0).UTC()`)}}
+}
+
+// fnDuration generates durations valid as PostgreSQL intervals,
+// that is with a microsecond precision.
+type fnDuration struct{}
+
+func (fnDuration) Id() string { return "Duration" }
+
+func (fnDuration) Type() types.Type {
+	return types.NewNamed(types.NewTypeName(0, types.NewPackage("time", "time"), "Duration", nil), types.Typ[types.Int64], nil)
+}
+
+func (f fnDuration) Render() []loader.Declaration {
+	return []loader.Declaration{{Id: f.Id(), Content: randFunction(f.Id(), "time.Duration",
+		"return time.Duration(r.Int63n(1e12)) * time.Microsecond")}}
+}
+
+// fnIP generates valid IPv4 and IPv6 addresses.
+type fnIP struct{}
+
+func (fnIP) Id() string { return "IP" }
+
+func (fnIP) Type() types.Type {
+	return types.NewNamed(types.NewTypeName(0, types.NewPackage("net", "net"), "IP", nil), types.NewSlice(types.Typ[types.Byte]), nil)
+}
+
+func (f fnIP) Render() []loader.Declaration {
+	return []loader.Declaration{{Id: f.Id(), Content: randFunction(f.Id(), "net.IP", `if r.Intn(4) == 0 {
+			ip := make(net.IP, net.IPv6len)
+			r.Read(ip)
+			return ip
+		}
+		return net.IPv4(byte(r.Intn(256)), byte(r.Intn(256)), byte(r.Intn(256)), byte(r.Intn(256)))`)}}
 }
 
 type fnArray struct {
@@ -141,14 +194,11 @@ func (f fnArray) Render() []loader.Declaration {
 
 	elemString := typeName(f.TargetPackage, f.Elem.Type())
 	decl := loader.Declaration{
-		Id: f.Id(), Content: fmt.Sprintf(`
-	func rand%s() [%d]%s {
-		var out [%d]%s
+		Id: f.Id(), Content: randFunction(f.Id(), fmt.Sprintf("[%d]%s", f.Length, elemString), fmt.Sprintf(`var out [%d]%s
 		for i := range out {
-			out[i] = rand%s()
+			out[i] = rand%sFrom(r)
 		}
-		return out
-	}`, f.Id(), f.Length, elemString, f.Length, elemString, f.Elem.Id()),
+		return out`, f.Length, elemString, f.Elem.Id())),
 	}
 
 	decls = append(decls, decl)
@@ -173,15 +223,12 @@ func (f fnSlice) Render() []loader.Declaration {
 
 	elemString := typeName(f.TargetPackage, f.Elem.Type())
 	decl := loader.Declaration{
-		Id: f.Id(), Content: fmt.Sprintf(`
-	func rand%s() []%s {
-		l := 40 + rand.Intn(10)
+		Id: f.Id(), Content: randFunction(f.Id(), "[]"+elemString, fmt.Sprintf(`l := 40 + r.Intn(10)
 		out := make([]%s, l)
 		for i := range out {
-			out[i] = rand%s()
+			out[i] = rand%sFrom(r)
 		}
-		return out
-	}`, f.Id(), elemString, elemString, f.Elem.Id()),
+		return out`, elemString, f.Elem.Id())),
 	}
 
 	decls = append(decls, decl)
@@ -209,15 +256,12 @@ func (f fnMap) Render() []loader.Declaration {
 	keyString := typeName(f.TargetPackage, f.Key.Type())
 	elemString := typeName(f.TargetPackage, f.Elem.Type())
 	decl := loader.Declaration{
-		Id: f.Id(), Content: fmt.Sprintf(`
-	func rand%s() map[%s]%s {
-		l := 40 + rand.Intn(10)
+		Id: f.Id(), Content: randFunction(f.Id(), fmt.Sprintf("map[%s]%s", keyString, elemString), fmt.Sprintf(`l := 40 + r.Intn(10)
 		out := make(map[%s]%s, l)
 		for i := 0; i < l; i++ {
-			out[rand%s()] = rand%s()
+			out[rand%sFrom(r)] = rand%sFrom(r)
 		}
-		return out
-	}`, f.Id(), keyString, elemString, keyString, elemString, f.Key.Id(), f.Elem.Id()),
+		return out`, keyString, elemString, f.Key.Id(), f.Elem.Id())),
 	}
 
 	decls = append(decls, decl)
@@ -228,7 +272,8 @@ func (f fnMap) Render() []loader.Declaration {
 type structField struct {
 	type_ dataFunction
 	Name  string
-	Id    string
+	field *types.Var
+	tag   string
 }
 
 // fnStruct generate a named random struct
@@ -236,6 +281,8 @@ type fnStruct struct {
 	TargetPackage string
 	Type_         *types.Named
 	Fields        []structField
+
+	checks map[string][]check // by Go type name, filled by HandleComment
 }
 
 func (f fnStruct) Id() string {
@@ -251,20 +298,32 @@ func (f fnStruct) Type() types.Type {
 	return f.Type_
 }
 
+// constrainedFields returns the fields, whose values are restricted
+// by their tags and the CHECK constraints of the table.
+func (f fnStruct) constrainedFields() []structField {
+	out := make([]structField, len(f.Fields))
+	for i, field := range f.Fields {
+		if _, isEnum := field.type_.(fnEnum); !isEnum {
+			if limits, ok := fieldLimits(field.field, field.tag, f.checks[f.Type_.Obj().Name()]); ok {
+				field.type_ = fnConstrained{Elem: field.type_, Limits: limits, TargetPackage: f.TargetPackage}
+			}
+		}
+		out[i] = field
+	}
+	return out
+}
+
 func (f fnStruct) Render() (decls []loader.Declaration) {
 	fieldsCode := ""
-	for _, field := range f.Fields {
+	for _, field := range f.constrainedFields() {
 		decls = append(decls, field.type_.Render()...)
-		fieldsCode += fmt.Sprintf("\t%s: rand%s(),\n", field.Name, field.Id)
+		fieldsCode += fmt.Sprintf("\t%s: rand%sFrom(r),\n", field.Name, field.type_.Id())
 	}
 	typeN := typeName(f.TargetPackage, f.Type_)
 	fCode := loader.Declaration{
-		Id: f.Id(), Content: fmt.Sprintf(`
-	func rand%s() %s {
-		return %s{
+		Id: f.Id(), Content: randFunction(f.Id(), typeN, fmt.Sprintf(`return %s{
 			%s
-		}
-	}`, f.Id(), typeN, typeN, fieldsCode),
+		}`, typeN, fieldsCode)),
 	}
 
 	decls = append(decls, fCode)
@@ -288,11 +347,8 @@ func (f fnPointer) Render() []loader.Declaration {
 	decls := f.Elem.Render()
 	elemString := typeName(f.TargetPackage, f.Elem.Type())
 	decl := loader.Declaration{
-		Id: f.Id(), Content: fmt.Sprintf(`
-	func rand%s() *%s {
-		data := rand%s()
-		return &data
-	}`, f.Id(), elemString, f.Elem.Id()),
+		Id: f.Id(), Content: randFunction(f.Id(), "*"+elemString, fmt.Sprintf(`data := rand%sFrom(r)
+		return &data`, f.Elem.Id())),
 	}
 
 	decls = append(decls, decl)
@@ -318,11 +374,8 @@ func (f fnNamed) Render() []loader.Declaration {
 
 	tn := typeName(f.TargetPackage, f.Type_)
 	decl := loader.Declaration{
-		Id: f.Id(),
-		Content: fmt.Sprintf(`
-	func rand%s() %s {
-		return %s(rand%s())
-	}`, f.Id(), tn, tn, f.Underlying.Id()),
+		Id:      f.Id(),
+		Content: randFunction(f.Id(), tn, fmt.Sprintf("return %s(rand%sFrom(r))", tn, f.Underlying.Id())),
 	}
 
 	decls = append(decls, decl)
@@ -351,12 +404,9 @@ func (f fnEnum) Render() []loader.Declaration {
 		origin = ""
 	}
 	return []loader.Declaration{{
-		Id: f.Id(), Content: fmt.Sprintf(`
-	func rand%s() %s {
-		choix := %s
-		i := rand.Intn(len(choix))
-		return choix[i]
-	}`, f.Id(), tn, f.Underlying.AsArray(origin)),
+		Id: f.Id(), Content: randFunction(f.Id(), tn, fmt.Sprintf(`choix := %s
+		i := r.Intn(len(choix))
+		return choix[i]`, f.Underlying.AsArray(origin))),
 	}}
 }
 
@@ -381,22 +431,17 @@ func (f fnInterface) Render() []loader.Declaration {
 		membersDecl []loader.Declaration
 	)
 	for _, member := range f.members {
-		choix = append(choix, fmt.Sprintf("rand%s(),\n", member.Id()))
+		choix = append(choix, fmt.Sprintf("rand%sFrom(r),\n", member.Id()))
 		membersDecl = append(membersDecl, member.Render()...)
 	}
 
 	qualifiedName := typeName(f.TargetPackage, f.typ_)
 	return append([]loader.Declaration{{
 		Id: f.Id(),
-		Content: fmt.Sprintf(`
-	func rand%s() %s {
-		choix := [...]%s{
+		Content: randFunction(f.typ_.Obj().Name(), qualifiedName, fmt.Sprintf(`choix := [...]%s{
 			%s
 		}
-		i := rand.Intn(%d)
-		return choix[i]
-	}`, f.typ_.Obj().Name(), qualifiedName, qualifiedName,
-			strings.Join(choix, ""),
-			len(f.members)),
+		i := r.Intn(%d)
+		return choix[i]`, qualifiedName, strings.Join(choix, ""), len(f.members))),
 	}}, membersDecl...)
 }
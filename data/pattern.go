@@ -0,0 +1,115 @@
+package data
+
+import (
+	"fmt"
+	"math/rand"
+	"regexp"
+	"regexp/syntax"
+	"strings"
+	"unicode/utf8"
+)
+
+const (
+	// patternSamples is the number of values matching a pattern
+	// built when generating the code
+	patternSamples = 20
+	// maxPatternRepeat is the maximum number of additional repetitions
+	// of the unbounded operators (*, + and {n,})
+	maxPatternRepeat = 5
+)
+
+// samplePattern writes to `out` a random string matched by `re`,
+// or returns an error for the operators not supported.
+func samplePattern(re *syntax.Regexp, r *rand.Rand, out *strings.Builder) error {
+	switch re.Op {
+	case syntax.OpEmptyMatch, syntax.OpBeginLine, syntax.OpEndLine, syntax.OpBeginText, syntax.OpEndText:
+	case syntax.OpLiteral:
+		out.WriteString(string(re.Rune))
+	case syntax.OpCharClass:
+		if len(re.Rune) == 0 {
+			return fmt.Errorf("empty character class")
+		}
+		out.WriteRune(classRune(re.Rune, r))
+	case syntax.OpAnyChar, syntax.OpAnyCharNotNL:
+		out.WriteByte("abcdefghijklmnopqrstuvwxyz"[r.Intn(26)])
+	case syntax.OpCapture:
+		return samplePattern(re.Sub[0], r, out)
+	case syntax.OpConcat:
+		for _, sub := range re.Sub {
+			if err := samplePattern(sub, r, out); err != nil {
+				return err
+			}
+		}
+	case syntax.OpAlternate:
+		return samplePattern(re.Sub[r.Intn(len(re.Sub))], r, out)
+	case syntax.OpStar, syntax.OpPlus, syntax.OpQuest, syntax.OpRepeat:
+		min, max := re.Min, re.Max
+		switch re.Op {
+		case syntax.OpStar:
+			min, max = 0, -1
+		case syntax.OpPlus:
+			min, max = 1, -1
+		case syntax.OpQuest:
+			min, max = 0, 1
+		}
+		if max == -1 {
+			max = min + maxPatternRepeat
+		}
+		for n := min + r.Intn(max-min+1); n > 0; n-- {
+			if err := samplePattern(re.Sub[0], r, out); err != nil {
+				return err
+			}
+		}
+	default:
+		return fmt.Errorf("operator %s not supported", re)
+	}
+	return nil
+}
+
+// classRune returns a rune of the class given by its `ranges`,
+// preferably a printable ASCII character.
+func classRune(ranges []rune, r *rand.Rand) rune {
+	for attempt := 0; attempt < 100; attempt++ {
+		c := rune('!' + r.Intn('~'-'!'+1))
+		for i := 0; i < len(ranges); i += 2 {
+			if ranges[i] <= c && c <= ranges[i+1] {
+				return c
+			}
+		}
+	}
+	i := 2 * r.Intn(len(ranges)/2)
+	return ranges[i] + rune(r.Int63n(int64(ranges[i+1]-ranges[i])+1))
+}
+
+// matchingValues returns distinct strings matched by `pattern`, whose length
+// is in [minLen, maxLen] (maxLen is ignored if it is -1). A fixed seed is used,
+// so that the generated code is stable.
+func matchingValues(pattern string, minLen, maxLen int) ([]string, error) {
+	re, err := syntax.Parse(pattern, syntax.Perl)
+	if err != nil {
+		return nil, err
+	}
+	check, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, err
+	}
+	r := rand.New(rand.NewSource(1))
+	seen := map[string]bool{}
+	var out []string
+	for attempt := 0; attempt < 100*patternSamples && len(out) < patternSamples; attempt++ {
+		var value strings.Builder
+		if err := samplePattern(re, r, &value); err != nil {
+			return nil, err
+		}
+		s := value.String()
+		if l := utf8.RuneCountInString(s); seen[s] || l < minLen || (maxLen != -1 && l > maxLen) || !check.MatchString(s) {
+			continue
+		}
+		seen[s] = true
+		out = append(out, s)
+	}
+	if len(out) == 0 {
+		return nil, fmt.Errorf("no value found with a length in [%d, %d]", minLen, maxLen)
+	}
+	return out, nil
+}
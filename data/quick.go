@@ -0,0 +1,41 @@
+package data
+
+import (
+	"fmt"
+	"go/types"
+
+	"github.com/benoitkugler/structgen/loader"
+)
+
+// quickType renders the Generate method of a named type,
+// implementing the quick.Generator interface.
+type quickType struct {
+	fn  dataFunction
+	typ *types.Named
+}
+
+// hasQuickGenerator returns true if a Generate method may be added to `typ`,
+// that is if it is not an interface nor a pointer, and does not already
+// have a Generate method.
+func hasQuickGenerator(typ *types.Named) bool {
+	switch typ.Underlying().(type) {
+	case *types.Interface, *types.Pointer:
+		return false
+	}
+	obj, _, _ := types.LookupFieldOrMethod(typ, true, typ.Obj().Pkg(), "Generate")
+	return obj == nil
+}
+
+func (q quickType) Render() []loader.Declaration {
+	name := q.typ.Obj().Name()
+	return []loader.Declaration{{
+		Id: "quick_" + name,
+		Content: fmt.Sprintf(`
+		var _ quick.Generator = (*%[1]s)(nil)
+
+		// Generate implements quick.Generator, using rand%[2]sFrom.
+		func (%[1]s) Generate(r *rand.Rand, size int) reflect.Value {
+			return reflect.ValueOf(rand%[2]sFrom(r))
+		}`, name, q.fn.Id()),
+	}}
+}
@@ -0,0 +1,241 @@
+package data
+
+import (
+	"fmt"
+	"go/types"
+	"math"
+	"strings"
+
+	"github.com/benoitkugler/structgen/loader"
+)
+
+// This file generates the gen<Id> functions, returning generators of the
+// pgregory.net/rapid library, which shrinks the failing values.
+// Since the generated code may not support type parameters, the
+// generators are used through the reflection based API of rapid (v0.4).
+
+// rapidType renders the rapid generator of a type.
+type rapidType struct {
+	fn dataFunction
+}
+
+func (r rapidType) Render() []loader.Declaration { return r.fn.Rapid() }
+
+// rapidFunction returns the code of the gen<id> function.
+func rapidFunction(id, body string) loader.Declaration {
+	return loader.Declaration{Id: id, Content: fmt.Sprintf(`
+	func gen%s() *rapid.Generator {
+		%s
+	}`, id, body)}
+}
+
+// rapidCustom returns the expression of a custom generator of `typ` values.
+func rapidCustom(typ, body string) string {
+	return fmt.Sprintf(`rapid.Custom(func(t *rapid.T) %s {
+		%s
+	})`, typ, body)
+}
+
+// rapidDraw returns the expression drawing a value from the generator of `fn`.
+func rapidDraw(fn dataFunction, targetPackage, label string) string {
+	return fmt.Sprintf("gen%s().Draw(t, %q).(%s)", fn.Id(), label, typeName(targetPackage, fn.Type()))
+}
+
+// rapidRunes is the alphabet of the strings : the control
+// characters are excluded, since PostgreSQL rejects some of them.
+var rapidRunes = loader.Declaration{Id: "rapidRunes", Content: `
+	var rapidRunes = rapid.RuneFrom(nil, unicode.L, unicode.M, unicode.N, unicode.P, unicode.S, unicode.Zs)`}
+
+// rapidIntegers are the names of the rapid generators of integers
+var rapidIntegers = map[types.BasicKind]string{
+	types.Int: "Int", types.Int8: "Int8", types.Int16: "Int16", types.Int32: "Int32", types.Int64: "Int64",
+	types.Uint: "Uint", types.Uint8: "Uint8", types.Uint16: "Uint16", types.Uint32: "Uint32",
+	types.Uint64: "Uint64", types.Uintptr: "Uintptr",
+}
+
+// rapidRange returns the generator of the numbers of `kind` in [lo, hi].
+func rapidRange(kind types.BasicKind, lo, hi float64) string {
+	if kind == types.Float64 {
+		return fmt.Sprintf("rapid.Float64Range(%v, %v)", lo, hi)
+	}
+	return fmt.Sprintf("rapid.%sRange(%d, %d)", rapidIntegers[kind], int64(lo), int64(hi))
+}
+
+func (f FnBasic) Rapid() []loader.Declaration {
+	var body string
+	switch kind := f.type_.Kind(); kind {
+	case types.Bool:
+		body = "return rapid.Bool()"
+	case types.Float64:
+		body = "return " + rapidRange(kind, 0, 999999)
+	case types.String:
+		return []loader.Declaration{rapidRunes, rapidFunction(f.Id(), "return rapid.StringOfN(rapidRunes, 0, 50, -1)")}
+	default:
+		if _, ok := rapidIntegers[kind]; !ok {
+			panic(fmt.Sprintf("basic type %v not supported", f.type_))
+		}
+		_, hi := integerRange(kind)
+		body = "return " + rapidRange(kind, 0, math.Min(hi, 999999))
+	}
+	return []loader.Declaration{rapidFunction(f.Id(), body)}
+}
+
+func (f fnTime) Rapid() []loader.Declaration {
+	return []loader.Declaration{rapidFunction(f.Id(), "return "+rapidCustom("time.Time", `seconds := rapid.Int64Range(0, math.MaxInt32).Draw(t, "seconds").(int64)
+		return time.Unix(seconds, 0).UTC()`))}
+}
+
+func (f fnDuration) Rapid() []loader.Declaration {
+	return []loader.Declaration{rapidFunction(f.Id(), "return "+rapidCustom("time.Duration",
+		`return time.Duration(rapid.Int64Range(0, 1e12).Draw(t, "microseconds").(int64)) * time.Microsecond`))}
+}
+
+func (f fnIP) Rapid() []loader.Declaration {
+	return []loader.Declaration{rapidFunction(f.Id(), "return "+rapidCustom("net.IP", `if rapid.Bool().Draw(t, "ipv6").(bool) {
+			return net.IP(rapid.SliceOfN(rapid.Byte(), net.IPv6len, net.IPv6len).Draw(t, "ip").([]byte))
+		}
+		b := rapid.SliceOfN(rapid.Byte(), 4, 4).Draw(t, "ip").([]byte)
+		return net.IPv4(b[0], b[1], b[2], b[3])`))}
+}
+
+func (f fnArray) Rapid() []loader.Declaration {
+	decls := f.Elem.Rapid()
+	typ := typeName(f.TargetPackage, f.Type())
+	return append(decls, rapidFunction(f.Id(), "return "+rapidCustom(typ, fmt.Sprintf(`var out %s
+		for i := range out {
+			out[i] = %s
+		}
+		return out`, typ, rapidDraw(f.Elem, f.TargetPackage, "elem")))))
+}
+
+func (f fnSlice) Rapid() []loader.Declaration {
+	decls := f.Elem.Rapid()
+	return append(decls, rapidFunction(f.Id(), fmt.Sprintf("return rapid.SliceOf(gen%s())", f.Elem.Id())))
+}
+
+func (f fnMap) Rapid() []loader.Declaration {
+	decls := append(f.Key.Rapid(), f.Elem.Rapid()...)
+	return append(decls, rapidFunction(f.Id(), fmt.Sprintf("return rapid.MapOf(gen%s(), gen%s())", f.Key.Id(), f.Elem.Id())))
+}
+
+func (f fnStruct) Rapid() (decls []loader.Declaration) {
+	typ := typeName(f.TargetPackage, f.Type_)
+	var fieldsCode string
+	for _, field := range f.constrainedFields() {
+		decls = append(decls, field.type_.Rapid()...)
+		fieldsCode += fmt.Sprintf("\t%s: %s,\n", field.Name, rapidDraw(field.type_, f.TargetPackage, field.Name))
+	}
+	return append(decls, rapidFunction(f.Id(), "return "+rapidCustom(typ, fmt.Sprintf(`return %s{
+			%s
+		}`, typ, fieldsCode))))
+}
+
+func (f fnPointer) Rapid() []loader.Declaration {
+	decls := f.Elem.Rapid()
+	return append(decls, rapidFunction(f.Id(), "return "+rapidCustom(typeName(f.TargetPackage, f.Type()), fmt.Sprintf(`data := %s
+		return &data`, rapidDraw(f.Elem, f.TargetPackage, "data")))))
+}
+
+func (f fnNamed) Rapid() []loader.Declaration {
+	decls := f.Underlying.Rapid()
+	tn := typeName(f.TargetPackage, f.Type_)
+	return append(decls, rapidFunction(f.Id(), "return "+rapidCustom(tn, fmt.Sprintf("return %s(%s)",
+		tn, rapidDraw(f.Underlying, f.TargetPackage, "value")))))
+}
+
+func (f fnEnum) Rapid() []loader.Declaration {
+	origin := f.Type_.Obj().Pkg().Name()
+	if origin == f.TargetPackage {
+		origin = ""
+	}
+	return []loader.Declaration{rapidFunction(f.Id(), fmt.Sprintf(`choix := %s
+		return rapid.SampledFrom(choix[:])`, f.Underlying.AsArray(origin)))}
+}
+
+func (f fnInterface) Rapid() []loader.Declaration {
+	var (
+		decls   []loader.Declaration
+		members []string
+	)
+	qualifiedName := typeName(f.TargetPackage, f.typ_)
+	for _, member := range f.members {
+		decls = append(decls, member.Rapid()...)
+		// the generators given to OneOf must have the same type
+		members = append(members, rapidCustom(qualifiedName, "return "+rapidDraw(member, f.TargetPackage, "member")))
+	}
+	return append(decls, rapidFunction(f.Id(), fmt.Sprintf(`return rapid.OneOf(
+			%s,
+		)`, strings.Join(members, ",\n"))))
+}
+
+func (f fnConstrained) Rapid() []loader.Declaration {
+	decls := f.Elem.Rapid()
+	tn := typeName(f.TargetPackage, f.Type())
+
+	var gen string // generator of the underlying values
+	switch under := f.Type().Underlying().(type) {
+	case *types.Basic:
+		info := under.Info()
+		switch {
+		case info&types.IsInteger != 0 || under.Kind() == types.Float64:
+			var lo, hi float64
+			if info&types.IsInteger != 0 {
+				lo, hi = f.numberRange(integerRange(under.Kind()))
+				lo, hi = math.Ceil(lo), math.Floor(hi)
+			} else {
+				lo, hi = f.numberRange(-math.MaxFloat64, math.MaxFloat64)
+			}
+			gen = rapidRange(under.Kind(), lo, hi)
+			if f.Limits.NonZero {
+				gen += fmt.Sprintf(".Filter(func(v %s) bool { return v != 0 })", under.Name())
+			}
+		case info&types.IsString != 0:
+			gen = f.rapidString()
+			decls = append(decls, rapidRunes)
+		}
+	case *types.Slice:
+		if elems, ok := collectionElem(f.Elem); ok {
+			decls = append(decls, elems[0].Rapid()...)
+			minLen, maxLen := f.lengthRange()
+			gen = fmt.Sprintf("rapid.SliceOfN(gen%s(), %d, %d)", elems[0].Id(), minLen, maxLen)
+		}
+	case *types.Map:
+		if elems, ok := collectionElem(f.Elem); ok {
+			decls = append(decls, elems[0].Rapid()...)
+			decls = append(decls, elems[1].Rapid()...)
+			minLen, maxLen := f.lengthRange()
+			gen = fmt.Sprintf("rapid.MapOfN(gen%s(), gen%s(), %d, %d)", elems[0].Id(), elems[1].Id(), minLen, maxLen)
+		}
+	}
+	// convert to named types
+	if underName := typeName(f.TargetPackage, f.Type().Underlying()); gen != "" && tn != underName {
+		gen = rapidCustom(tn, fmt.Sprintf("return %s(%s.Draw(t, \"value\").(%s))", tn, gen, underName))
+	}
+	if gen == "" { // not supported : use the unconstrained values
+		gen = fmt.Sprintf("gen%s()", f.Elem.Id())
+	}
+	return append(decls, rapidFunction(f.Id(), "return "+gen))
+}
+
+// rapidString returns the generator of the constrained strings
+func (f fnConstrained) rapidString() string {
+	const ascii = `rapid.RuneFrom([]rune("abcdefghijklmnopqrstuvwxyz0123456789"))`
+	switch {
+	case f.Limits.Format == "email":
+		lo, hi := f.formatLengths(len("@.com"), 2)
+		return rapidCustom("string", fmt.Sprintf(`n := rapid.IntRange(%[2]d, %[3]d).Draw(t, "length").(int)
+			user := rapid.IntRange(1, n-1).Draw(t, "user length").(int)
+			return rapid.StringOfN(%[1]s, user, user, -1).Draw(t, "user").(string) + "@" +
+			rapid.StringOfN(%[1]s, n-user, n-user, -1).Draw(t, "domain").(string) + ".com"`, ascii, lo, hi))
+	case f.Limits.Format == "url":
+		lo, hi := f.formatLengths(len("https://.com/"), 1)
+		return rapidCustom("string", fmt.Sprintf(`n := rapid.IntRange(%[2]d, %[3]d).Draw(t, "length").(int)
+			host := rapid.IntRange(1, n).Draw(t, "host length").(int)
+			return "https://" + rapid.StringOfN(%[1]s, host, host, -1).Draw(t, "host").(string) + ".com/" +
+			rapid.StringOfN(%[1]s, n-host, n-host, -1).Draw(t, "path").(string)`, ascii, lo, hi))
+	case f.Limits.Pattern != "":
+		return fmt.Sprintf("rapid.StringMatching(%q)", f.Limits.Pattern)
+	}
+	minLen, maxLen := f.lengthRange()
+	return fmt.Sprintf("rapid.StringOfN(rapidRunes, %d, %d, -1)", minLen, maxLen)
+}
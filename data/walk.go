@@ -15,28 +15,76 @@ import (
 
 var _ loader.Handler = handler{}
 
+// output selects the code generated by the handler
+type output uint8
+
+const (
+	outputRand  output = iota // rand<Type> functions
+	outputQuick               // Generate methods, for testing/quick
+	outputRapid               // gen<Type> functions, for pgregory.net/rapid
+)
+
 type handler struct {
 	EnumsTable  enums.EnumTable
 	PackageName string
 
+	output output
+
 	itfs *interfaces.Analyzer
 	// mapping from go types to the one generated by the analysis,
 	// used in processInterfaces()
 	types map[types.Type]dataFunction
+	// CHECK constraints of the tables, by Go type name
+	checks map[string][]check
 }
 
 func NewHandler(packageName string, enums enums.EnumTable) loader.Handler {
+	return newHandler(packageName, enums, outputRand)
+}
+
+// NewQuickHandler returns a handler implementing the quick.Generator
+// interface for the types of the package, using the functions
+// generated by NewHandler, which must be in the same package.
+func NewQuickHandler(packageName string, enums enums.EnumTable) loader.Handler {
+	return newHandler(packageName, enums, outputQuick)
+}
+
+// NewRapidHandler returns a handler generating a gen<Type> function for each type,
+// returning a generator of the pgregory.net/rapid library, which shrinks
+// the failing values. The values respect the same constraints as NewHandler.
+// The generated code uses the reflection based API of rapid v0.4.x
+// (Draw returns an interface{}), and does not compile with rapid v1 :
+// it is type checked against the version required by the go.mod of structgen.
+func NewRapidHandler(packageName string, enums enums.EnumTable) loader.Handler {
+	return newHandler(packageName, enums, outputRapid)
+}
+
+func newHandler(packageName string, enums enums.EnumTable, output output) handler {
 	return handler{
+		output:      output,
 		PackageName: packageName,
 		EnumsTable:  enums,
 		itfs:        interfaces.NewAnalyser(),
 		types:       make(map[types.Type]dataFunction),
+		checks:      make(map[string][]check),
 	}
 }
 
 func (d handler) HandleType(typ types.Type) loader.Type {
 	d.itfs.NewInterface(typ)
-	return d.analyseType(typ)
+	fn := d.analyseType(typ)
+	switch d.output {
+	case outputQuick:
+		named, ok := typ.(*types.Named)
+		if !ok || !hasQuickGenerator(named) {
+			return nil
+		}
+		return quickType{fn: fn, typ: named}
+	case outputRapid:
+		return rapidType{fn}
+	default:
+		return fn
+	}
 }
 
 func (d handler) analyseType(typ types.Type) dataFunction {
@@ -55,15 +103,52 @@ func (d handler) analyseType(typ types.Type) dataFunction {
 	return out
 }
 
-func (d handler) HandleComment(comment loader.Comment) error { return nil }
+// HandleComment records the CHECK constraints, used to generate valid values.
+func (d handler) HandleComment(comment loader.Comment) error {
+	if comment.Tag == "sql" {
+		d.checks[comment.TypeName] = append(d.checks[comment.TypeName], parseChecks(comment.Content)...)
+	}
+	return nil
+}
 
 func (d handler) Header() string {
 	d.processInterfaces()
 
+	switch d.output {
+	case outputQuick:
+		return fmt.Sprintf(`package %s
+
+		// Code generated by structgen. DO NOT EDIT.
+
+		// The Generate methods use the rand<Type>From functions,
+		// and ignore the size hint.
+		`, d.PackageName)
+	case outputRapid:
+		// rapid is imported explicitly since it may not be found by the formatter
+		return fmt.Sprintf(`package %s
+
+		// Code generated by structgen. DO NOT EDIT.
+
+		import "pgregory.net/rapid"
+		`, d.PackageName)
+	}
+
 	return fmt.Sprintf(`package %s
 	
 	// Code generated by structgen. DO NOT EDIT.
 
+	// globalSource delegates to the global source of math/rand,
+	// which is safe for concurrent use.
+	type globalSource struct{}
+
+	func (globalSource) Int63() int64     { return rand.Int63() }
+	func (globalSource) Uint64() uint64   { return rand.Uint64() }
+	func (globalSource) Seed(seed int64) { rand.Seed(seed) }
+
+	// globalRand is used by the rand<Type> functions. To reproduce
+	// the values, use the rand<Type>From functions with a seeded source :
+	//	r := rand.New(rand.NewSource(seed))
+	var globalRand = rand.New(globalSource{})
 	`, d.PackageName)
 }
 func (d handler) Footer() string { return "" }
@@ -75,13 +160,15 @@ func (d handler) convertFields(structType *types.Struct) (fields []structField)
 			continue
 		}
 		dataFn := d.analyseType(field.Type())
-		fields = append(fields, structField{Name: field.Name(), Id: dataFn.Id(), type_: dataFn})
+		fields = append(fields, structField{Name: field.Name(), type_: dataFn, field: field, tag: structType.Tag(i)})
 	}
 	return fields
 }
 
-func isTime(typ *types.Named) bool {
-	return typ.Obj().Pkg().Name() == "time" && typ.Obj().Name() == "Time"
+func isTime(typ *types.Named) bool { return isNamedType(typ, "time", "Time") }
+
+func isNamedType(typ *types.Named, pkg, name string) bool {
+	return typ.Obj().Pkg() != nil && typ.Obj().Pkg().Path() == pkg && typ.Obj().Name() == name
 }
 
 // return the corresponding function, as well as all its dependencies.
@@ -101,8 +188,12 @@ func (d handler) createType(typ types.Type) (decl dataFunction) {
 				}
 			} else {
 				fields := d.convertFields(st)
-				decl = fnStruct{TargetPackage: d.PackageName, Type_: named, Fields: fields}
+				decl = fnStruct{TargetPackage: d.PackageName, Type_: named, Fields: fields, checks: d.checks}
 			}
+		} else if isNamedType(named, "time", "Duration") {
+			decl = fnDuration{}
+		} else if isNamedType(named, "net", "IP") {
+			decl = fnIP{}
 		} else if _, isInterface := typ.Underlying().(*types.Interface); isInterface {
 			decl = &fnInterface{TargetPackage: d.PackageName, typ_: named}
 		} else if enum, isEnum := d.EnumsTable[named.Obj().Name()]; isEnum {
@@ -1,12 +1,21 @@
 package data
 
 import (
+	"go/ast"
+	"go/importer"
+	"go/parser"
+	"go/token"
+	"go/types"
 	"os"
 	"path/filepath"
+	"reflect"
+	"regexp"
+	"strings"
 	"testing"
 
 	"github.com/benoitkugler/structgen/enums"
 	"github.com/benoitkugler/structgen/loader"
+	_ "pgregory.net/rapid" // the version checked by TestRapidCompiles
 )
 
 func TestMain(t *testing.T) {
@@ -35,3 +44,152 @@ func TestMain(t *testing.T) {
 		t.Fatal(err)
 	}
 }
+
+func TestSeedableFunctions(t *testing.T) {
+	h := NewHandler("models", nil).(handler)
+	fn := h.analyseType(types.NewSlice(types.Typ[types.Int64]))
+	decls := fn.Render()
+	if len(decls) != 2 {
+		t.Fatal(decls)
+	}
+	code := decls[1].Content
+	if !strings.Contains(code, "func randSliceint64From(r *rand.Rand) []int64") ||
+		!strings.Contains(code, "out[i] = randint64From(r)") ||
+		!strings.Contains(code, "func randSliceint64() []int64 { return randSliceint64From(globalRand) }") {
+		t.Fatal(code)
+	}
+}
+
+func TestParseChecks(t *testing.T) {
+	checks := parseChecks("ADD CHECK(total > 0 AND char_length(code) BETWEEN 2 AND 12 AND name <> '' AND total = ANY(sizes))")
+	expected := []check{
+		{column: "total", op: ">", value: 0},
+		{column: "code", isLength: true, op: ">=", value: 2},
+		{column: "code", isLength: true, op: "<=", value: 12},
+		{column: "name", nonEmpty: true},
+	}
+	if !reflect.DeepEqual(checks, expected) {
+		t.Fatal(checks)
+	}
+}
+
+func TestFieldLimits(t *testing.T) {
+	pkg := types.NewPackage("test", "test")
+	total := types.NewField(0, pkg, "Total", types.Typ[types.Int], false)
+	limits, ok := fieldLimits(total, `json:"total" validate:"max=100"`, parseChecks("ADD CHECK(total > 0)"))
+	if !ok || *limits.Min != 1 || *limits.Max != 100 {
+		t.Fatal(limits)
+	}
+	code := types.NewField(0, pkg, "Code", types.Typ[types.String], false)
+	limits, ok = fieldLimits(code, `sql_len:"12" validate:"len>=2"`, nil)
+	if !ok || limits.MinLen != 2 || limits.MaxLen != 12 {
+		t.Fatal(limits)
+	}
+	if _, ok = fieldLimits(code, `json:"code"`, nil); ok {
+		t.Fatal("expected no limits")
+	}
+
+	limits, _ = fieldLimits(total, `validate:"min=1,max=100"`, nil)
+	decls := fnConstrained{Elem: FnBasic{types.Typ[types.Int]}, Limits: limits}.Render()
+	if code := decls[len(decls)-1].Content; !strings.Contains(code, "v = 1 + r.Int63n(100)") {
+		t.Fatal(code)
+	}
+
+	// the email length includes the fixed runes
+	limits, _ = fieldLimits(code, `validate:"len<=12,email"`, nil)
+	decls = fnConstrained{Elem: FnBasic{types.Typ[types.String]}, Limits: limits}.Render()
+	if code := decls[len(decls)-1].Content; !strings.Contains(code, "n := 2 + r.Intn(6)") {
+		t.Fatal(code)
+	}
+
+	// the empty string is only generated when the limits allow it
+	if strings.Contains(fnString(), `return ""`) {
+		t.Fatal(fnString())
+	}
+	limits, _ = fieldLimits(code, `validate:"nonempty"`, nil)
+	decls = fnConstrained{Elem: FnBasic{types.Typ[types.String]}, Limits: limits}.Render()
+	if code := decls[len(decls)-1].Content; !strings.Contains(code, "randRunesFrom(r, letterRunes2, 1)) // shortest") {
+		t.Fatal(code)
+	}
+}
+
+func TestMatchingValues(t *testing.T) {
+	pattern := `^[A-Z]{2}-\d+(x|yz)?$`
+	values, err := matchingValues(pattern, 0, 6)
+	if err != nil || len(values) == 0 {
+		t.Fatal(values, err)
+	}
+	re := regexp.MustCompile(pattern)
+	for _, value := range values {
+		if !re.MatchString(value) || len(value) > 6 {
+			t.Errorf("invalid value %q", value)
+		}
+	}
+	if _, err := matchingValues(`^[a-z]{10}$`, 0, 5); err == nil {
+		t.Fatal("expected error for an impossible length")
+	}
+}
+
+func TestGenerators(t *testing.T) {
+	pkg := types.NewPackage("models", "models")
+	named := types.NewNamed(types.NewTypeName(0, pkg, "Codes", nil), types.NewSlice(types.Typ[types.String]), nil)
+
+	quick := NewQuickHandler("models", nil).HandleType(named).Render()
+	if len(quick) != 1 || !strings.Contains(quick[0].Content, "func (Codes) Generate(r *rand.Rand, size int) reflect.Value") ||
+		!strings.Contains(quick[0].Content, "reflect.ValueOf(randCodesFrom(r))") {
+		t.Fatal(quick)
+	}
+
+	rapid := NewRapidHandler("models", nil).HandleType(named).Render()
+	code := rapid[len(rapid)-1].Content
+	if !strings.Contains(code, "func genCodes() *rapid.Generator") ||
+		!strings.Contains(code, "return Codes(genSlicestring().Draw(t, \"value\").([]string))") {
+		t.Fatal(code)
+	}
+}
+
+func TestRapidCompiles(t *testing.T) {
+	pkg := types.NewPackage("models", "models")
+	timePkg := types.NewPackage("time", "time")
+	timeType := types.NewNamed(types.NewTypeName(0, timePkg, "Time", nil), types.NewStruct(nil, nil), nil)
+	fields := []*types.Var{
+		types.NewField(0, pkg, "Id", types.Typ[types.Int64], false),
+		types.NewField(0, pkg, "Email", types.Typ[types.String], false),
+		types.NewField(0, pkg, "Code", types.Typ[types.String], false),
+		types.NewField(0, pkg, "Total", types.Typ[types.Int16], false),
+		types.NewField(0, pkg, "Ratio", types.Typ[types.Float64], false),
+		types.NewField(0, pkg, "Tags", types.NewSlice(types.Typ[types.String]), false),
+		types.NewField(0, pkg, "Scores", types.NewMap(types.Typ[types.String], types.Typ[types.Int]), false),
+		types.NewField(0, pkg, "Parent", types.NewPointer(types.Typ[types.Bool]), false),
+		types.NewField(0, pkg, "Created", timeType, false),
+	}
+	tags := []string{"", `validate:"len<=20,email"`, `validate:"pattern=^[A-Z]{3}-[0-9]+$"`, `validate:"min=1,max=100"`, "", "", "", "", ""}
+	named := types.NewNamed(types.NewTypeName(0, pkg, "User", nil), types.NewStruct(fields, tags), nil)
+
+	h := NewRapidHandler("models", nil)
+	code := h.Header()
+	seen := map[string]bool{}
+	for _, decl := range h.HandleType(named).Render() {
+		if !seen[decl.Id] {
+			seen[decl.Id] = true
+			code += decl.Content + "\n"
+		}
+	}
+	// the other imports are added by the formatter
+	code = strings.Replace(code, `import "pgregory.net/rapid"`,
+		`import ("math"; "net"; "time"; "unicode"; "pgregory.net/rapid")
+		var _, _, _ = math.MaxInt32, net.IPv4len, unicode.L`, 1)
+	code += "type User struct { Id int64; Email, Code string; Total int16; Ratio float64; Tags []string; " +
+		"Scores map[string]int; Parent *bool; Created time.Time }\n"
+
+	fset := token.NewFileSet()
+	file, err := parser.ParseFile(fset, "gen_rapid.go", code, 0)
+	if err != nil {
+		t.Fatal(err, code)
+	}
+	// rapid is resolved from the version required by go.mod
+	conf := types.Config{Importer: importer.ForCompiler(fset, "source", nil)}
+	if _, err = conf.Check("models", fset, []*ast.File{file}, nil); err != nil {
+		t.Fatal(err, code)
+	}
+}
@@ -22,7 +22,7 @@ func (t EnumTable) AsLookupTable() map[string]string {
 	out := map[string]string{}
 	for _, enum := range t {
 		for _, value := range enum.Values {
-			out[fmt.Sprintf("%s.%s", enum.Name, value.VarName)] = strings.ReplaceAll(value.Value, `"`, `'`)
+			out[fmt.Sprintf("%s.%s", enum.Name, value.VarName)] = value.SQLLiteral()
 		}
 	}
 	return out
@@ -43,7 +43,9 @@ func (t EnumTable) Lookup(ty *types.Named) (Type, *types.Basic, bool) {
 type Type struct {
 	Name   string
 	Values []EnumValue
-	IsInt  bool
+	// IsInt is only set for enums with an unsigned underlying type :
+	// use the underlying type to detect the other numeric enums.
+	IsInt bool
 }
 
 // AsTuple returns a tuple of valid values
@@ -52,10 +54,9 @@ type Type struct {
 func (e Type) AsTuple() string {
 	chunks := make([]string, len(e.Values))
 	for i, val := range e.Values {
-		chunks[i] = val.Value
+		chunks[i] = val.SQLLiteral()
 	}
-	out := fmt.Sprintf("(%s)", strings.Join(chunks, ", "))
-	return strings.ReplaceAll(out, `"`, `'`)
+	return fmt.Sprintf("(%s)", strings.Join(chunks, ", "))
 }
 
 // AsArray returns the code for a Go array
@@ -5,6 +5,7 @@ import (
 	"go/ast"
 	"go/token"
 	"go/types"
+	"strconv"
 	"strings"
 
 	"golang.org/x/tools/go/packages"
@@ -18,6 +19,16 @@ type EnumValue struct {
 	Label    string // how to display the value
 }
 
+// SQLLiteral returns the value as SQL code : strings are
+// single quoted, with their quotes escaped.
+func (v EnumValue) SQLLiteral() string {
+	s, err := strconv.Unquote(v.Value)
+	if err != nil { // not a string
+		return v.Value
+	}
+	return "'" + strings.ReplaceAll(s, "'", "''") + "'"
+}
+
 type enumLabels map[string]string // varName -> text
 
 type enumsValue map[string]enumLabels // local type name -> datas
@@ -82,7 +93,7 @@ func aggregate(pa *packages.Package, enums enumsValue) EnumTable {
 				continue
 			}
 
-			constVal := decl.Val().String()
+			constVal := decl.Val().ExactString()
 			if named, isNamed := decl.Type().(*types.Named); isNamed {
 				typeName := named.Obj().Name()
 				isInt := false
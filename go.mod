@@ -5,4 +5,5 @@ go 1.16
 require (
 	github.com/labstack/echo/v4 v4.7.0
 	golang.org/x/tools v0.1.0
+	pgregory.net/rapid v0.4.8
 )
@@ -1,9 +1,11 @@
 package interfaces
 
 import (
+	"go/types"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"strings"
 	"testing"
 
 	"github.com/benoitkugler/structgen/loader"
@@ -64,3 +66,30 @@ func TestFetchAllTypes(t *testing.T) {
 		t.Fatal()
 	}
 }
+
+func TestJSONTests(t *testing.T) {
+	pkg := types.NewPackage("test", "test")
+	newNamed := func(name string, under types.Type) *types.Named {
+		return types.NewNamed(types.NewTypeName(0, pkg, name, nil), under, nil)
+	}
+	itf := Interface{
+		Name:    newNamed("union1", types.NewInterfaceType(nil, nil)),
+		Members: []*types.Named{newNamed("member1", types.Typ[types.Int]), newNamed("member2", types.NewStruct(nil, nil))},
+	}
+
+	var code string
+	for _, decl := range testDecls(itfSlice{name: "ITFSlice", elem: itf}) {
+		code += decl.Content
+	}
+	for _, expected := range []string{
+		"func TestJSONWrapper_union1(t *testing.T)",
+		"randmember1(),\nrandmember2(),",
+		"json.Marshal(union1Wrapper{Data: value})",
+		"func TestJSONSlice_ITFSlice(t *testing.T)",
+		"var got ITFSlice",
+	} {
+		if !strings.Contains(code, expected) {
+			t.Fatalf("missing %s in %s", expected, code)
+		}
+	}
+}
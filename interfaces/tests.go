@@ -0,0 +1,119 @@
+package interfaces
+
+import (
+	"fmt"
+	"go/types"
+
+	"github.com/benoitkugler/structgen/loader"
+)
+
+// jsonRoundTrips is the number of random values tested for each member
+const jsonRoundTrips = 10
+
+var _ loader.Handler = testHandler{}
+
+// testHandler generates the tests of the JSON routines
+// generated by `handler`
+type testHandler struct {
+	*handler
+}
+
+// NewTestHandler returns a handler generating tests checking that
+// every member of the interfaces (and of the slices of interfaces) survives
+// a JSON round trip. The random values are built by the functions of the rand mode,
+// which must be generated in the same package.
+func NewTestHandler(packageName string) loader.Handler {
+	return testHandler{handler: &handler{packageName: packageName, analyzer: NewAnalyser()}}
+}
+
+func (h testHandler) Header() string {
+	return fmt.Sprintf(`package %s
+
+	// Code generated by structgen/interfaces. DO NOT EDIT
+	`, h.packageName)
+}
+
+func (h testHandler) HandleType(typ types.Type) loader.Type {
+	if out := h.handler.HandleType(typ); out != nil {
+		return jsonTest{out}
+	}
+	return nil
+}
+
+// jsonTest renders the tests of the interfaces used by a type
+type jsonTest struct {
+	typ loader.Type
+}
+
+func (j jsonTest) Render() []loader.Declaration { return testDecls(j.typ) }
+
+func testDecls(typ loader.Type) (out []loader.Declaration) {
+	switch typ := typ.(type) {
+	case Interface:
+		return []loader.Declaration{{Id: typ.Name.Obj().Name() + "_test", Content: typ.test()}}
+	case itfSlice:
+		return append(testDecls(typ.elem), loader.Declaration{Id: typ.name + "_test", Content: typ.test()})
+	case class:
+		for _, field := range typ.fields {
+			if field.type_ != nil {
+				out = append(out, testDecls(field.type_)...)
+			}
+		}
+	}
+	return out
+}
+
+// randMembers returns the expressions building a random value for each member
+func (itf Interface) randMembers() string {
+	var code string
+	for _, member := range itf.Members {
+		code += fmt.Sprintf("rand%s(),\n", member.Obj().Name())
+	}
+	return code
+}
+
+func (itf Interface) test() string {
+	name := itf.Name.Obj().Name()
+	return fmt.Sprintf(`
+	func TestJSONWrapper_%[1]s(t *testing.T) {
+		for i := 0; i < %[3]d; i++ {
+			for _, value := range []%[1]s{
+				%[2]s
+			} {
+				b, err := json.Marshal(%[1]sWrapper{Data: value})
+				if err != nil {
+					t.Fatal(err)
+				}
+				var got %[1]sWrapper
+				if err = json.Unmarshal(b, &got); err != nil {
+					t.Fatal(err)
+				}
+				if !reflect.DeepEqual(value, got.Data) {
+					t.Fatalf("%%T: expected %%v, got %%v", value, value, got.Data)
+				}
+			}
+		}
+	}`, name, itf.randMembers(), jsonRoundTrips)
+}
+
+func (s itfSlice) test() string {
+	return fmt.Sprintf(`
+	func TestJSONSlice_%[1]s(t *testing.T) {
+		for i := 0; i < %[3]d; i++ {
+			value := %[1]s{
+				%[2]s
+			}
+			b, err := json.Marshal(value)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got %[1]s
+			if err = json.Unmarshal(b, &got); err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(value, got) {
+				t.Fatalf("expected %%v, got %%v", value, got)
+			}
+		}
+	}`, s.name, s.elem.randMembers(), jsonRoundTrips)
+}
@@ -0,0 +1,19 @@
+// Package ormtest provides the fixtures shared by the tests
+// of the orm packages, which don't load real sources.
+package ormtest
+
+import (
+	"go/types"
+
+	"github.com/benoitkugler/structgen/orm"
+)
+
+// NewTable returns the table of a struct with the given field names, types and tags.
+func NewTable(name string, fieldNames []string, typs []types.Type, tags []string) orm.GoSQLTable {
+	pkg := types.NewPackage("test", "test")
+	fields := make([]*types.Var, len(fieldNames))
+	for i, fieldName := range fieldNames {
+		fields[i] = types.NewField(0, pkg, fieldName, typs[i], false)
+	}
+	return orm.NewGoSQLTable(name, types.NewStruct(fields, tags), nil)
+}
@@ -33,8 +33,13 @@ func (l Composites) Header() string {
 	type scanner interface {
 		Scan(...interface{}) error
 	}
-	
-	`, PackageComposites)
+
+	// DB is implemented by *sql.DB and *sql.Tx
+	type DB interface {
+		Query(query string, args ...interface{}) (*sql.Rows, error)
+	}
+	%s
+	`, PackageComposites, orm.CodecHelpers(l.tables))
 }
 
 func (l Composites) Footer() string {
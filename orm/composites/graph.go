@@ -1,7 +1,9 @@
 package composites
 
 import (
+	"fmt"
 	"io"
+	"log"
 	"sort"
 	"strings"
 	"text/template"
@@ -27,7 +29,7 @@ func scanOne{{ .Name }}(row scanner) ({{ .Name }}, error) {
 		{{ range $i, $table := .Tables }}
 				{{- range .Fields -}}
 					{{- if .Exported -}}
-						&s.{{ $table.Name }}.{{ .GoName }},
+						{{ .ScanArg (print "s." $table.Name) }},
 					{{- else -}}
 						&dummy, 
 					{{- end -}}
@@ -65,11 +67,38 @@ func Scan{{ .Name}}s(rs *sql.Rows) ({{.Name}}s , error) {
 	}
 	return structs, nil
 }
+
+{{ with .FromClause -}}
+// Select{{ $.Name }}s joins the tables {{ range $i, $t := $.Tables }}{{ if $i }}, {{ end }}{{ $t.TableName }}{{ end }}.
+// 'where' is an optional SQL condition, whose columns must be qualified
+// by their table name, using 'args' as parameters ($1, $2, ...).
+func Select{{ $.Name }}s(tx DB, where string, args ...interface{}) ({{ $.Name }}s, error) {
+	query := "SELECT {{ range $i, $t := $.Tables }}{{ if $i }}, {{ end }}{{ $t.TableName }}.*{{ end }} FROM {{ . }}"
+	{{- with $.NotDeleted }}
+	query += " WHERE {{ . }}"
+	if where != "" {
+		query += " AND (" + where + ")"
+	}
+	{{- else }}
+	if where != "" {
+		query += " WHERE " + where
+	}
+	{{- end }}
+	rows, err := tx.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	return Scan{{ $.Name }}s(rows)
+}
+{{- end }}
 `))
 
 type compositeTable struct {
 	Origin string
 	Tables []orm.GoSQLTable
+	// Joins[i] is the foreign key leading to Tables[i],
+	// or has an empty column if there is none (always for i = 0)
+	Joins []lien
 }
 
 func (c compositeTable) Name() string {
@@ -106,9 +135,42 @@ func (c compositeTable) HasPrivateFields() bool {
 	return false
 }
 
+// FromClause returns the tables joined on the foreign keys of the path,
+// or the empty string if two consecutive tables are not directly related
+// (that is, through a link table, which is not part of the composite).
+func (c compositeTable) FromClause() string {
+	out := c.Tables[0].TableName()
+	for i := 1; i < len(c.Tables); i++ {
+		l, next := c.Joins[i], c.Tables[i]
+		if l.column == "" {
+			log.Printf("warning : tables %s and %s are not directly related, Select%ss is not generated",
+				c.Tables[i-1].TableName(), next.TableName(), c.Name())
+			return ""
+		}
+		// the foreign key goes from the previous table to the next one
+		cond := fmt.Sprintf("%s.%s = %s.%s", l.table, l.column, l.foreign, next.IDField().SQLName)
+		out += " JOIN " + next.TableName() + " ON " + cond
+	}
+	return out
+}
+
+// NotDeleted returns the conditions excluding the soft deleted rows
+// of each table, or the empty string.
+func (c compositeTable) NotDeleted() string {
+	var conds []string
+	for _, table := range c.Tables {
+		if col := table.SoftDeleteColumn(); col != "" {
+			conds = append(conds, table.TableName()+"."+col+" IS NULL")
+		}
+	}
+	return strings.Join(conds, " AND ")
+}
+
+// lien is the foreign key `column` of `table`, referencing `foreign`
 type lien struct {
 	table   string
 	foreign string
+	column  string
 }
 
 type graph struct {
@@ -123,35 +185,42 @@ func newGraph(sts []orm.GoSQLTable) graph {
 		out.tables[sqlName] = s
 		fs := s.Fields
 		for _, key := range fs.ForeignKeys() {
-			out.liens[lien{table: sqlName, foreign: key.ForeignKey()}] = true
+			out.liens[lien{table: sqlName, foreign: key.ForeignKey(), column: key.SQLName}] = true
 		}
 	}
 	return out
 }
 
-// return the next tables
-func (g graph) voisins(table string) []string {
-	var out sort.StringSlice
+// return the foreign keys of `table`, sorted by next table and column
+func (g graph) voisins(table string) []lien {
+	var out []lien
 	for l := range g.liens {
 		if l.table == table {
-			out = append(out, l.foreign)
+			out = append(out, l)
 		}
 	}
-	sort.Sort(out)
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].foreign != out[j].foreign {
+			return out[i].foreign < out[j].foreign
+		}
+		return out[i].column < out[j].column
+	})
 	return out
 }
 
-func (g graph) addNext(origin string, paths [][]string) [][]string {
+// a path is a list of liens, whose `foreign` are the tables of the path;
+// the first one only has its `foreign` table set
+func (g graph) addNext(origin string, paths [][]lien) [][]lien {
 	vs := g.voisins(origin)
-	var out [][]string
+	var out [][]lien
 	for _, next := range vs {
-		var nextPaths [][]string
+		var nextPaths [][]lien
 		// update paths
 		for _, path := range paths {
-			nextPaths = append(nextPaths, append(path, next))
+			nextPaths = append(nextPaths, append(append([]lien(nil), path...), next))
 		}
 		// recurse on next neighbour
-		nextPathsRec := g.addNext(next, nextPaths)
+		nextPathsRec := g.addNext(next.foreign, nextPaths)
 		// add to global
 		out = append(out, nextPathsRec...)
 	}
@@ -175,22 +244,34 @@ func (g graph) sortedOrigines() []string {
 }
 
 // returns all paths, excepted singletons
-// path are trimmed of link tables
-func (g graph) extractPaths() [][]string {
+// path are trimmed of link tables : the table following
+// a link table has no join
+func (g graph) extractPaths() [][]lien {
 	var (
-		out  [][]string
+		out  [][]lien
 		seen = map[string]bool{}
 	)
 	for _, origin := range g.sortedOrigines() {
-		paths := g.addNext(origin, [][]string{{origin}})
+		paths := g.addNext(origin, [][]lien{{{foreign: origin}}})
 		for _, path := range paths {
-			var trimmed []string
-			for _, table := range path {
-				if g.tables[table].HasID() {
-					trimmed = append(trimmed, table)
+			var (
+				trimmed []lien
+				tables  []string
+				skipped bool
+			)
+			for _, l := range path {
+				if !g.tables[l.foreign].HasID() {
+					skipped = true
+					continue
+				}
+				if skipped {
+					l = lien{foreign: l.foreign}
+					skipped = false
 				}
+				trimmed = append(trimmed, l)
+				tables = append(tables, l.foreign)
 			}
-			ha := hash(trimmed)
+			ha := hash(tables)
 			if len(trimmed) >= 2 && !seen[ha] {
 				seen[ha] = true
 				out = append(out, trimmed)
@@ -200,16 +281,65 @@ func (g graph) extractPaths() [][]string {
 	return out
 }
 
-func (g graph) render(origin string, out io.Writer) error {
-	paths := g.extractPaths()
-	for _, path := range paths {
-		args := compositeTable{Origin: origin}
-		for _, table := range path {
-			args.Tables = append(args.Tables, g.tables[table])
+// composites returns the composite types of the paths
+func (g graph) composites(origin string) []compositeTable {
+	var out []compositeTable
+	for _, path := range g.extractPaths() {
+		args := compositeTable{Origin: origin, Joins: path}
+		for _, l := range path {
+			args.Tables = append(args.Tables, g.tables[l.foreign])
 		}
+		out = append(out, args)
+	}
+	return out
+}
+
+func (g graph) render(origin string, out io.Writer) error {
+	for _, args := range g.composites(origin) {
 		if err := templateComposite.Execute(out, args); err != nil {
 			return err
 		}
 	}
 	return nil
 }
+
+// TopologicalOrder sorts the tables so that each table comes after the
+// tables it references through its foreign keys. Self references are ignored,
+// and the tables of a cycle are added in name order, with a warning.
+func TopologicalOrder(sts []orm.GoSQLTable) []orm.GoSQLTable {
+	g := newGraph(sts)
+	var (
+		out  []orm.GoSQLTable
+		done = map[string]bool{}
+	)
+	for len(done) < len(g.tables) {
+		var ready []string
+		for _, table := range g.sortedOrigines() {
+			if done[table] {
+				continue
+			}
+			isReady := true
+			for _, l := range g.voisins(table) {
+				if _, known := g.tables[l.foreign]; known && l.foreign != table && !done[l.foreign] {
+					isReady = false
+				}
+			}
+			if isReady {
+				ready = append(ready, table)
+			}
+		}
+		if len(ready) == 0 { // cycle : add the remaining tables
+			for _, table := range g.sortedOrigines() {
+				if !done[table] {
+					log.Printf("warning : table %s is part of a foreign key cycle", table)
+					ready = append(ready, table)
+				}
+			}
+		}
+		for _, table := range ready {
+			done[table] = true
+			out = append(out, g.tables[table])
+		}
+	}
+	return out
+}
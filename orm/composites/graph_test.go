@@ -1,11 +1,15 @@
 package composites
 
 import (
+	"go/types"
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
 
+	"github.com/benoitkugler/structgen/internal/ormtest"
 	"github.com/benoitkugler/structgen/loader"
+	"github.com/benoitkugler/structgen/orm"
 )
 
 func TestGraph(t *testing.T) {
@@ -28,3 +32,59 @@ func TestGraph(t *testing.T) {
 		t.Fatal(err)
 	}
 }
+
+func TestFromClause(t *testing.T) {
+	int64T := types.Typ[types.Int64]
+	user := ormtest.NewTable("User", []string{"Id"}, []types.Type{int64T}, []string{`json:"id"`})
+	order := ormtest.NewTable("Order", []string{"Id", "IdUser"}, []types.Type{int64T, int64T}, []string{`json:"id"`, `json:"id_user"`})
+	invoice := ormtest.NewTable("Invoice", []string{"Id", "IdOrder"}, []types.Type{int64T, int64T}, []string{`json:"id"`, `json:"id_order"`})
+
+	// fromClauses returns the FROM clauses of the composites, by name
+	fromClauses := func(tables ...orm.GoSQLTable) map[string]string {
+		out := map[string]string{}
+		for _, c := range newGraph(tables).composites("test") {
+			out[c.Name()] = c.FromClause()
+		}
+		return out
+	}
+
+	clauses := fromClauses(invoice, order, user)
+	expected := "invoices JOIN orders ON invoices.id_order = orders.id JOIN users ON orders.id_user = users.id"
+	if clauses["InvoiceOrderUser"] != expected || clauses["OrderUser"] != "orders JOIN users ON orders.id_user = users.id" {
+		t.Fatal(clauses)
+	}
+	// the join uses the foreign key of the path, even if the tables are related by several ones
+	message := ormtest.NewTable("Message", []string{"Id", "IdSender", "IdReceiver"}, []types.Type{int64T, int64T, int64T},
+		[]string{`json:"id"`, `json:"id_sender" sql_foreign_key:"user"`, `json:"id_receiver" sql_foreign_key:"user"`})
+	clauses = fromClauses(message, user)
+	if clauses["MessageUser"] != "messages JOIN users ON messages.id_receiver = users.id" {
+		t.Fatal(clauses)
+	}
+	// tables related through a link table
+	group := ormtest.NewTable("Group", []string{"Id"}, []types.Type{int64T}, []string{`json:"id"`})
+	link := ormtest.NewTable("UserGroup", []string{"IdUser", "IdGroup"}, []types.Type{int64T, int64T}, []string{`json:"id_user"`, `json:"id_group"`})
+	c := compositeTable{Tables: []orm.GoSQLTable{user, group}, Joins: []lien{{foreign: "users"}, {foreign: "groups"}}}
+	if c.FromClause() != "" {
+		t.Fatal(c.FromClause())
+	}
+	if clauses = fromClauses(user, group, link); len(clauses) != 0 {
+		t.Fatal(clauses)
+	}
+}
+
+func TestTopologicalOrder(t *testing.T) {
+	int64T := types.Typ[types.Int64]
+	user := ormtest.NewTable("User", []string{"Id"}, []types.Type{int64T}, []string{`json:"id"`})
+	order := ormtest.NewTable("Order", []string{"Id", "IdUser"}, []types.Type{int64T, int64T}, []string{`json:"id"`, `json:"id_user"`})
+	invoice := ormtest.NewTable("Invoice", []string{"Id", "IdOrder"}, []types.Type{int64T, int64T}, []string{`json:"id"`, `json:"id_order"`})
+	// self reference
+	category := ormtest.NewTable("Category", []string{"Id", "IdCategory"}, []types.Type{int64T, int64T}, []string{`json:"id"`, `json:"id_category"`})
+
+	var names []string
+	for _, table := range TopologicalOrder([]orm.GoSQLTable{invoice, order, user, category}) {
+		names = append(names, table.Name)
+	}
+	if strings.Join(names, " ") != "Category User Order Invoice" {
+		t.Fatal(names)
+	}
+}
@@ -71,7 +71,7 @@ func (s SQLField) ForeignConstraint(tableGoName string) (ForeignKeyConstraint, b
 		sqlField:       s.SQLName,
 		sqlSourceTable: tableName(tableGoName),
 		sqlTargetTable: targetTable,
-		deleteAction:   s.onDeleteConstraint(),
+		deleteAction:   s.OnDelete(),
 	}
 	return ct, targetTable != ""
 }
@@ -82,12 +82,22 @@ var reUnique = regexp.MustCompile(`(?i)ADD UNIQUE\s?\((.*)\)`)
 // return the column made unique by a UNIQUE(col) constraint
 // or the empty string(
 func IsUniqueConstraint(ct loader.Comment) string {
-	matchs := reUnique.FindStringSubmatch(ct.Content)
-	if len(matchs) > 0 {
-		cols := strings.Split(matchs[1], ",")
-		if len(cols) == 1 { // unique column
-			return strings.TrimSpace(cols[0])
-		}
+	if cols := UniqueConstraintColumns(ct); len(cols) == 1 { // unique column
+		return cols[0]
 	}
 	return ""
 }
+
+// UniqueConstraintColumns returns the columns of a UNIQUE(col1, col2, ...)
+// constraint, or nil.
+func UniqueConstraintColumns(ct loader.Comment) []string {
+	matchs := reUnique.FindStringSubmatch(ct.Content)
+	if len(matchs) == 0 {
+		return nil
+	}
+	cols := strings.Split(matchs[1], ",")
+	for i, col := range cols {
+		cols[i] = strings.TrimSpace(col)
+	}
+	return cols
+}
@@ -3,20 +3,64 @@ package creation
 import (
 	"fmt"
 	"go/types"
+	"sort"
 	"strings"
 
 	"github.com/benoitkugler/structgen/enums"
 	"github.com/benoitkugler/structgen/loader"
 	"github.com/benoitkugler/structgen/orm"
 	"github.com/benoitkugler/structgen/orm/jsonsql"
+	"github.com/benoitkugler/structgen/orm/sqltypes"
 )
 
-func NewGenHandler(enumsTable enums.EnumTable, eraseJSONDecl bool) loader.Handler {
-	return &sqlGenHandler{enumsTable: enumsTable, lookupEnumTable: enumsTable.AsLookupTable(), eraseJSONDecl: eraseJSONDecl}
+// Options tunes the generated SQL statements.
+type Options struct {
+	// EraseJSONDecl adds instructions to remove existing
+	// JSON validation functions.
+	EraseJSONDecl bool
+	// IndexForeignKeys adds an index on every foreign key column
+	// not already indexed.
+	IndexForeignKeys bool
+	// Outbox creates the table storing the change records
+	// written by the generated CRUD functions.
+	Outbox bool
+	// NativeEnums stores the string enums with PostgreSQL
+	// enum types instead of CHECK constraints.
+	NativeEnums bool
+	// IncrementalJSON names the JSON validation functions after a hash
+	// of their content, instead of dropping and creating them all.
+	// The existing databases are upgraded with the script
+	// generated by NewUpgradeHandler.
+	IncrementalJSON bool
+	// ExplainJSON adds, for each JSON validation function, a
+	// structgen_explain_json_<type> function listing the path
+	// and the reason of each problem of an invalid value.
+	ExplainJSON bool
+}
+
+func NewGenHandler(enumsTable enums.EnumTable, options Options) loader.Handler {
+	return &sqlGenHandler{
+		enumsTable:      enumsTable,
+		lookupEnumTable: enumsTable.AsLookupTable(),
+		options:         options,
+		indexedColumns:  make(map[string]bool),
+		nativeEnums:     make(map[string]sqltypes.NativeEnum),
+		jsonFunctions:   make(map[string]bool),
+		keys:            make(map[string]orm.SQLField),
+	}
 }
 
 type TableGen struct {
 	orm.GoSQLTable
+	explainJSON bool
+
+	// primary keys of the tables with an ID, by SQL table name,
+	// shared by all the tables and complete when rendering
+	keys map[string]orm.SQLField
+
+	// only used with IncrementalJSON
+	jsonDecls []loader.Declaration
+	jsonNames map[string]string // function name and constraint names -> hashed name
 }
 
 // func (t TableGen) Id() string {
@@ -26,20 +70,35 @@ type TableGen struct {
 func (t TableGen) Render() []loader.Declaration {
 	fieldsDecl := make([]string, len(t.Fields))
 	fieldsName := make([]string, len(t.Fields))
+	var naturalKeys []string
 	for i, f := range t.Fields {
+		// foreign keys use the type of the referenced key
+		if key, ok := t.keys[f.ForeignKey()]; ok {
+			f = f.References(key)
+		}
 		fieldsDecl[i] = "\t" + f.CreateStmt()
 		fieldsName[i] = f.SQLName
+		if f.Primary == orm.Natural {
+			naturalKeys = append(naturalKeys, f.SQLName)
+		}
+	}
+	// generated keys are declared with their column
+	if len(naturalKeys) != 0 {
+		fieldsDecl = append(fieldsDecl, fmt.Sprintf("\tPRIMARY KEY (%s)", strings.Join(naturalKeys, ", ")))
 	}
 
 	// json validation first
 	out := t.jsonValidations()
+	if t.jsonNames != nil {
+		out = t.jsonDecls
+	}
 
 	decl := loader.Declaration{
 		Id: t.Name,
-		Content: fmt.Sprintf(`
+		Content: jsonsql.Rename(fmt.Sprintf(`
 CREATE TABLE %s (
 %s
-);`, t.TableName(), strings.Join(fieldsDecl, ",\n")),
+);`, t.TableName(), strings.Join(fieldsDecl, ",\n")), t.jsonNames),
 	}
 
 	return append(out, decl)
@@ -51,6 +110,9 @@ func (t TableGen) jsonValidations() []loader.Declaration {
 	for _, f := range t.Fields {
 		if f.Type.JSON != nil {
 			out = append(out, f.Type.JSON.Validations()...)
+			if t.explainJSON {
+				out = append(out, f.Type.JSON.Explanations()...)
+			}
 		}
 	}
 	return out
@@ -65,7 +127,19 @@ type sqlGenHandler struct {
 	lookupEnumTable map[string]string // cached from `enumsTable`
 	enumsTable      enums.EnumTable
 	constraints     []constraint
-	eraseJSONDecl   bool
+	indexes         []orm.Index
+	options         Options
+
+	foreignKeyIndexes []orm.Index     // only used with IndexForeignKeys
+	indexedColumns    map[string]bool // <table>.<column> leading an index
+
+	nativeEnums map[string]sqltypes.NativeEnum // only used with NativeEnums
+
+	keys map[string]orm.SQLField // see TableGen.keys
+
+	// only used with IncrementalJSON
+	jsonConstraints []jsonConstraint
+	jsonFunctions   map[string]bool // hashed names
 }
 
 func (l sqlGenHandler) Header() string {
@@ -73,17 +147,43 @@ func (l sqlGenHandler) Header() string {
 	-- DO NOT EDIT - autogenerated by structgen 
 		   
 	`
-	if l.eraseJSONDecl {
+	if l.options.EraseJSONDecl {
 		out += jsonsql.SetupSQLCode
 	}
+	if l.options.Outbox {
+		out += orm.OutboxCreateStmt
+	}
+	// the enum types are used by the tables
+	var enumNames []string
+	for name := range l.nativeEnums {
+		enumNames = append(enumNames, name)
+	}
+	sort.Strings(enumNames)
+	for _, name := range enumNames {
+		out += l.nativeEnums[name].CreateStmt() + "\n"
+	}
 	return out
 }
 
 func (l sqlGenHandler) Footer() string {
-	chunks := make([]string, 0, len(l.constraints))
+	chunks := make([]string, 0, len(l.constraints)+len(l.indexes))
 	for _, c := range l.constraints {
 		chunks = append(chunks, c.Render())
 	}
+	// indexes are created after the constraints
+	for _, ix := range l.indexes {
+		chunks = append(chunks, ix.Render())
+		if ix.Where == "" {
+			l.indexedColumns[ix.Table()+"."+ix.Columns[0]] = true
+		}
+	}
+	// foreign keys already covered by an other index are skipped
+	for _, ix := range l.foreignKeyIndexes {
+		if l.indexedColumns[ix.Table()+"."+ix.Columns[0]] {
+			continue
+		}
+		chunks = append(chunks, ix.Render())
+	}
 	return strings.Join(chunks, "\n")
 }
 
@@ -92,14 +192,59 @@ func (l *sqlGenHandler) HandleType(typ types.Type) loader.Type {
 	if !isTable {
 		return nil
 	}
-	decl := TableGen{GoSQLTable: table}
+	if l.options.NativeEnums {
+		for i, f := range table.Fields {
+			if enum, ok := f.NativeEnum(); ok {
+				table.Fields[i].Type.Type = enum
+				l.nativeEnums[enum.SQLName] = enum
+			}
+		}
+	}
+	if table.HasID() {
+		l.keys[table.TableName()] = table.IDField()
+	}
+	decl := TableGen{GoSQLTable: table, explainJSON: l.options.ExplainJSON, keys: l.keys}
+	if l.options.IncrementalJSON {
+		decl.jsonDecls, decl.jsonNames = jsonsql.HashNames(decl.jsonValidations())
+		for _, d := range decl.jsonDecls {
+			l.jsonFunctions[d.Id] = true
+		}
+		for _, f := range table.Fields {
+			if f.Type.JSON != nil {
+				function := jsonsql.FunctionName(f.Type.JSON)
+				ct := jsonConstraint{
+					table:    table.TableName(),
+					column:   f.SQLName,
+					function: decl.jsonNames[function],
+				}
+				l.jsonConstraints = append(l.jsonConstraints, ct)
+				// the constraint name is shortened to keep the hash
+				decl.jsonNames[f.SQLName+"_"+function] = ct.name()
+			}
+		}
+	}
 
-	// register the constraints
+	// register the constraints and indexes
 	for _, f := range table.Fields {
 		foreignConstraint, has := f.ForeignConstraint(decl.Name)
 		if has {
 			l.constraints = append(l.constraints, foreignConstraint)
 		}
+		index, has, err := f.Index(decl.Name)
+		if err != nil {
+			panic(err)
+		}
+		if has {
+			l.indexes = append(l.indexes, index)
+		}
+	}
+	if pk := table.Fields.Primary(); len(pk) != 0 {
+		l.indexedColumns[table.TableName()+"."+pk[0].SQLName] = true
+	}
+	if l.options.IndexForeignKeys {
+		for _, f := range table.Fields.ForeignKeys() {
+			l.foreignKeyIndexes = append(l.foreignKeyIndexes, f.ForeignKeyIndex(decl.Name))
+		}
 	}
 
 	return decl
@@ -107,7 +252,18 @@ func (l *sqlGenHandler) HandleType(typ types.Type) loader.Type {
 
 func (l *sqlGenHandler) HandleComment(comment loader.Comment) error {
 	switch comment.Tag {
+	case "sql_index":
+		ix, err := orm.NewIndex(comment, l.lookupEnumTable)
+		if err != nil {
+			return err
+		}
+		l.indexes = append(l.indexes, ix)
+		return nil
 	case "sql":
+		// unique constraints create an index
+		if cols := orm.UniqueConstraintColumns(comment); len(cols) != 0 {
+			l.indexedColumns[orm.TableName(comment.TypeName)+"."+cols[0]] = true
+		}
 	case "noTableSql":
 		comment.TypeName = ""
 	default:
@@ -1,8 +1,11 @@
 package creation
 
 import (
+	"fmt"
+	"go/types"
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
 
 	"github.com/benoitkugler/structgen/enums"
@@ -24,7 +27,7 @@ func TestSQL(t *testing.T) {
 	if err != nil {
 		t.Fatal(err)
 	}
-	handler := NewGenHandler(en, false)
+	handler := NewGenHandler(en, Options{})
 	decls, err := loader.WalkFile(fullPath, pkg, handler)
 	if err != nil {
 		t.Fatal(err)
@@ -35,3 +38,86 @@ func TestSQL(t *testing.T) {
 		t.Fatal(err)
 	}
 }
+
+func TestIncrementalJSON(t *testing.T) {
+	pkg := types.NewPackage("test", "test")
+	options := types.NewNamed(types.NewTypeName(0, pkg, "Options", nil), types.NewStruct([]*types.Var{
+		types.NewField(0, pkg, "A", types.Typ[types.Int], false),
+	}, nil), nil)
+	user := types.NewNamed(types.NewTypeName(0, pkg, "User", nil), types.NewStruct([]*types.Var{
+		types.NewField(0, pkg, "Id", types.Typ[types.Int64], false),
+		types.NewField(0, pkg, "Opts", options, false),
+	}, []string{`json:"id"`, `json:"opts"`}), nil)
+
+	h := NewGenHandler(nil, Options{IncrementalJSON: true})
+	code := loader.ToString(h.HandleType(user).Render())
+	if strings.Contains(h.Footer(), "DO $$") {
+		t.Fatal("the upgrade must be a separate script")
+	}
+	upgrade := NewUpgradeHandler(nil, Options{})
+	upgradeCode := loader.ToString(upgrade.HandleType(user).Render())
+	footer := upgrade.Footer()
+	if strings.Contains(upgradeCode, "CREATE TABLE") || !strings.Contains(upgradeCode, "CREATE OR REPLACE FUNCTION structgen_validate_json_tes_Options_") {
+		t.Fatal(upgradeCode)
+	}
+
+	var function string
+	for _, line := range strings.Split(code, "\n") {
+		if strings.Contains(line, "CREATE OR REPLACE FUNCTION structgen_validate_json_tes_Options_") {
+			function = strings.Fields(line)[4]
+		}
+	}
+	if function == "" {
+		t.Fatal(code)
+	}
+	for _, check := range []string{
+		fmt.Sprintf("opts jsonb  NOT NULL CONSTRAINT opts_%s CHECK (%s(opts))", function, function),
+	} {
+		if !strings.Contains(code, check) {
+			t.Errorf("missing %s in\n%s", check, code)
+		}
+	}
+	for _, check := range []string{
+		fmt.Sprintf("ALTER TABLE users ADD CONSTRAINT opts_%s CHECK (%s(opts)) NOT VALID;", function, function),
+		fmt.Sprintf("'%s')", function),
+		"WHERE NOT convalidated",
+	} {
+		if !strings.Contains(footer, check) {
+			t.Errorf("missing %s in\n%s", check, footer)
+		}
+	}
+}
+
+func TestForeignKeyTypes(t *testing.T) {
+	pkg := types.NewPackage("test", "test")
+	newTable := func(name string, fields []*types.Var, tags []string) *types.Named {
+		return types.NewNamed(types.NewTypeName(0, pkg, name, nil), types.NewStruct(fields, tags), nil)
+	}
+	stringT := types.Typ[types.String]
+	document := newTable("Document", []*types.Var{types.NewField(0, pkg, "Ref", stringT, false)}, []string{`json:"ref" sql:",pk,uuid"`})
+	country := newTable("Country", []*types.Var{types.NewField(0, pkg, "Code", stringT, false)}, []string{`json:"code" sql:",pk"`})
+	share := newTable("Share", []*types.Var{
+		types.NewField(0, pkg, "IdDocument", stringT, false),
+		types.NewField(0, pkg, "IdCountry", stringT, false),
+	}, []string{`json:"id_document"`, `json:"id_country"`})
+
+	h := NewGenHandler(nil, Options{})
+	var tables []loader.Type
+	for _, typ := range []types.Type{share, document, country} { // the order of declaration does not matter
+		tables = append(tables, h.HandleType(typ))
+	}
+	var code string
+	for _, table := range tables { // rendered after the walk
+		code += loader.ToString(table.Render())
+	}
+	for _, check := range []string{
+		"ref uuid PRIMARY KEY DEFAULT gen_random_uuid()",
+		"code varchar  NOT NULL,\n\tPRIMARY KEY (code)",
+		"id_document uuid  NOT NULL",
+		"id_country varchar  NOT NULL",
+	} {
+		if !strings.Contains(code, check) {
+			t.Errorf("missing %s in\n%s", check, code)
+		}
+	}
+}
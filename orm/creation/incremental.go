@@ -0,0 +1,125 @@
+package creation
+
+import (
+	"fmt"
+	"go/types"
+	"sort"
+	"strings"
+
+	"github.com/benoitkugler/structgen/enums"
+	"github.com/benoitkugler/structgen/loader"
+	"github.com/benoitkugler/structgen/orm/jsonsql"
+)
+
+// jsonConstraint is the CHECK constraint validating a JSON column.
+type jsonConstraint struct {
+	table, column string
+	function      string // hashed name
+}
+
+func (c jsonConstraint) name() string { return jsonsql.ConstraintName(c.column, c.function) }
+
+// Render returns a block replacing, on an existing table, the previous
+// constraint of the column, if its validation function has changed.
+// The previous constraints are found with the column they check, since their
+// names may have been truncated.
+// The new constraint is added as NOT VALID, so that the existing rows
+// are not checked while the table is locked.
+func (c jsonConstraint) Render() string {
+	return fmt.Sprintf(`
+DO $$
+DECLARE
+	previous text;
+BEGIN
+	IF EXISTS (SELECT FROM pg_constraint WHERE conrelid = '%[1]s'::regclass AND conname = '%[3]s') THEN
+		RETURN;
+	END IF;
+	FOR previous IN SELECT conname FROM pg_constraint WHERE conrelid = '%[1]s'::regclass AND contype = 'c'
+		AND conkey = ARRAY[(SELECT attnum FROM pg_attribute WHERE attrelid = '%[1]s'::regclass AND attname = '%[2]s')]
+		AND pg_get_constraintdef(oid) LIKE '%%structgen\_validate\_json\_%%' LOOP
+		EXECUTE format('ALTER TABLE %[1]s DROP CONSTRAINT %%I', previous);
+	END LOOP;
+	ALTER TABLE %[1]s ADD CONSTRAINT %[3]s CHECK (%[4]s(%[2]s)) NOT VALID;
+	RAISE NOTICE 'constraint %[3]s must be validated : ALTER TABLE %[1]s VALIDATE CONSTRAINT %[3]s;';
+END $$;`, c.table, c.column, c.name(), c.function)
+}
+
+// incrementalJSONFooter returns the statements upgrading an existing database :
+// the changed constraints are replaced, the unused functions are removed,
+// and the constraints to validate are listed.
+func incrementalJSONFooter(constraints []jsonConstraint, functions map[string]bool) string {
+	chunks := []string{`
+-- JSON validation : statements upgrading an existing database`}
+	for _, c := range constraints {
+		chunks = append(chunks, c.Render())
+	}
+
+	names := make([]string, 0, len(functions))
+	for fn := range functions {
+		names = append(names, fmt.Sprintf("'%s'", fn))
+	}
+	sort.Strings(names)
+	chunks = append(chunks, fmt.Sprintf(`
+-- functions not used anymore
+DO $$
+DECLARE
+	fn regprocedure;
+BEGIN
+	FOR fn IN SELECT oid::regprocedure FROM pg_proc
+		WHERE (starts_with (proname, 'structgen_validate_json') OR starts_with (proname, 'structgen_explain_json'))
+		AND pg_function_is_visible(oid) AND proname NOT IN (%s) LOOP
+		BEGIN
+			EXECUTE 'DROP FUNCTION ' || fn;
+		EXCEPTION
+			WHEN dependent_objects_still_exist THEN
+				RAISE NOTICE 'function %% is still used', fn;
+		END;
+	END LOOP;
+END $$;
+
+-- constraints to validate, with ALTER TABLE <table_name> VALIDATE CONSTRAINT <constraint_name>
+SELECT conrelid::regclass AS table_name, conname AS constraint_name FROM pg_constraint
+	WHERE NOT convalidated AND pg_get_constraintdef(oid) LIKE '%%structgen\_validate\_json\_%%';`, strings.Join(names, ", ")))
+	return strings.Join(chunks, "\n")
+}
+
+var _ loader.Handler = upgradeHandler{}
+
+// upgradeHandler generates the script upgrading an existing database
+// to the JSON validations of the sql_gen mode with IncrementalJSON : it creates
+// the new validation functions, replaces the changed constraints and
+// removes the unused functions. The tables are not created.
+type upgradeHandler struct {
+	*sqlGenHandler
+}
+
+// NewUpgradeHandler returns a handler generating the upgrade script of
+// the JSON validations. It is meant to be run on the databases created with
+// the IncrementalJSON option, which is implied.
+func NewUpgradeHandler(enumsTable enums.EnumTable, options Options) loader.Handler {
+	options.IncrementalJSON = true
+	return upgradeHandler{sqlGenHandler: NewGenHandler(enumsTable, options).(*sqlGenHandler)}
+}
+
+func (l upgradeHandler) Header() string {
+	return `
+	-- DO NOT EDIT - autogenerated by structgen 
+	`
+}
+
+func (l upgradeHandler) HandleType(typ types.Type) loader.Type {
+	table, ok := l.sqlGenHandler.HandleType(typ).(TableGen)
+	if !ok {
+		return nil
+	}
+	return jsonFunctions(table.jsonDecls)
+}
+
+func (l upgradeHandler) Footer() string {
+	return incrementalJSONFooter(l.jsonConstraints, l.jsonFunctions)
+}
+
+// jsonFunctions are the (renamed) JSON validation functions of a table
+type jsonFunctions []loader.Declaration
+
+func (j jsonFunctions) Render() []loader.Declaration { return j }
@@ -0,0 +1,141 @@
+package crud
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Options modifies the generated code.
+type Options struct {
+	// IsTest generates the tests of the CRUD functions
+	IsTest bool
+	// WithContext adds a context.Context as first argument of
+	// the generated functions, and the QueryHook interface.
+	WithContext bool
+	// Outbox records the changes made by the generated functions
+	// in the outbox table. The rows changed by the ON DELETE actions
+	// of the foreign keys are not recorded.
+	Outbox bool
+}
+
+// CtxParam returns the context parameter of the generated functions,
+// or the empty string.
+func (m structSQL) CtxParam() string {
+	if m.options.WithContext {
+		return "ctx context.Context, "
+	}
+	return ""
+}
+
+// CtxArg returns the context argument passed to the generated
+// functions, or the empty string.
+func (m structSQL) CtxArg() string {
+	if m.options.WithContext {
+		return "ctx, "
+	}
+	return ""
+}
+
+// Call returns the start of a call to the DB `method` (one of
+// Query, QueryRow, Exec or Prepare), issued by the generated function `name`,
+// which is reported to the query hook.
+func (m structSQL) Call(method, name string) string {
+	if m.options.WithContext {
+		return fmt.Sprintf("%s%sContext(ctx, tx, %q, ", strings.ToLower(method[:1]), method[1:], name)
+	}
+	return "tx." + method + "("
+}
+
+// QueryArgs returns the arguments passed to the query builder
+// methods running a query, for the generated function `name`.
+func (m structSQL) QueryArgs(name string) string {
+	if m.options.WithContext {
+		return fmt.Sprintf("ctx, tx, %q", name)
+	}
+	return "tx"
+}
+
+// StmtExec returns the start of a call to (*sql.Stmt).Exec
+func (m structSQL) StmtExec() string {
+	if m.options.WithContext {
+		return "stmt.ExecContext(ctx, "
+	}
+	return "stmt.Exec("
+}
+
+// StmtQueryRow returns the start of a call to (*sql.Stmt).QueryRow
+func (m structSQL) StmtQueryRow() string {
+	if m.options.WithContext {
+		return "stmt.QueryRowContext(ctx, "
+	}
+	return "stmt.QueryRow("
+}
+
+const dbInterfaceNoContext = `
+// DB groups transaction like objects
+type DB interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+	Query(query string, args ...interface{}) (*sql.Rows, error)
+	QueryRow(query string, args ...interface{}) *sql.Row 
+	Prepare(query string) (*sql.Stmt, error)
+}`
+
+const dbInterfaceContext = `
+// DB groups transaction like objects
+type DB interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row 
+	PrepareContext(ctx context.Context, query string) (*sql.Stmt, error)
+}
+
+// QueryHook is notified around each query, for instance for
+// tracing or metrics. 'name' is the generated function issuing the query.
+type QueryHook interface {
+	// BeforeQuery returns the context used by the query.
+	BeforeQuery(ctx context.Context, name, query string, args []interface{}) context.Context
+	AfterQuery(ctx context.Context, name string, err error)
+}
+
+// Hook, if not nil, is called around each query.
+var Hook QueryHook
+
+func queryContext(ctx context.Context, tx DB, name, query string, args ...interface{}) (*sql.Rows, error) {
+	if Hook == nil {
+		return tx.QueryContext(ctx, query, args...)
+	}
+	ctx = Hook.BeforeQuery(ctx, name, query, args)
+	rows, err := tx.QueryContext(ctx, query, args...)
+	Hook.AfterQuery(ctx, name, err)
+	return rows, err
+}
+
+func queryRowContext(ctx context.Context, tx DB, name, query string, args ...interface{}) *sql.Row {
+	if Hook == nil {
+		return tx.QueryRowContext(ctx, query, args...)
+	}
+	ctx = Hook.BeforeQuery(ctx, name, query, args)
+	row := tx.QueryRowContext(ctx, query, args...)
+	Hook.AfterQuery(ctx, name, row.Err())
+	return row
+}
+
+func execContext(ctx context.Context, tx DB, name, query string, args ...interface{}) (sql.Result, error) {
+	if Hook == nil {
+		return tx.ExecContext(ctx, query, args...)
+	}
+	ctx = Hook.BeforeQuery(ctx, name, query, args)
+	res, err := tx.ExecContext(ctx, query, args...)
+	Hook.AfterQuery(ctx, name, err)
+	return res, err
+}
+
+func prepareContext(ctx context.Context, tx DB, name, query string) (*sql.Stmt, error) {
+	if Hook == nil {
+		return tx.PrepareContext(ctx, query)
+	}
+	ctx = Hook.BeforeQuery(ctx, name, query, nil)
+	stmt, err := tx.PrepareContext(ctx, query)
+	Hook.AfterQuery(ctx, name, err)
+	return stmt, err
+}`
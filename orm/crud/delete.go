@@ -0,0 +1,171 @@
+package crud
+
+import (
+	"fmt"
+	"strings"
+	"text/template"
+)
+
+// code included when some Plan<Table>Delete functions are generated
+const deletePlan = `
+// DeleteEffect is the effect of a delete on the rows
+// refering to the deleted ones.
+type DeleteEffect struct {
+	Table  string // SQL table name
+	Column string // foreign key column
+	Action string // ON DELETE action : CASCADE, SET NULL, SET DEFAULT, RESTRICT or NO ACTION
+	Count  int    // number of rows affected
+}
+
+// DeletePlan describes the rows affected by a delete,
+// following the ON DELETE actions of the foreign keys.
+type DeletePlan struct {
+	Table   string // SQL table name
+	Count   int    // number of rows deleted in Table
+	Effects []DeleteEffect
+}
+
+// Blocked returns ` + "`true`" + ` if some rows prevent the delete,
+// because of a RESTRICT or NO ACTION foreign key.
+func (p DeletePlan) Blocked() bool {
+	for _, effect := range p.Effects {
+		if (effect.Action == "RESTRICT" || effect.Action == "NO ACTION") && effect.Count != 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// Deleted returns the number of deleted rows, including the cascades.
+func (p DeletePlan) Deleted() int {
+	out := p.Count
+	for _, effect := range p.Effects {
+		if effect.Action == "CASCADE" {
+			out += effect.Count
+		}
+	}
+	return out
+}
+`
+
+// deleteStep is a foreign key refering to a deleted table
+type deleteStep struct {
+	Index    int    // used to name the variables
+	Table    string // SQL name of the refering table
+	Column   string // the foreign key
+	Action   string
+	From     string // SQL argument with the deleted ids
+	IDColumn string // for cascades with dependent tables
+	ScanIDs  string // for cascades with dependent tables
+}
+
+// HasDependents returns `true` if the ids of the cascaded rows are needed.
+func (s deleteStep) HasDependents() bool { return s.ScanIDs != "" }
+
+// deleteAction normalizes the `sql_on_delete` tag
+func deleteAction(tag string) string {
+	if tag = strings.ToUpper(strings.TrimSpace(tag)); tag == "" {
+		return "NO ACTION"
+	}
+	return tag
+}
+
+// deleteSteps walks the foreign keys refering to `parent`, whose deleted ids are
+// given by the SQL expression `from`. Cascades are followed, excepted for the
+// tables already in `path`, to avoid cycles.
+func (l handler) deleteSteps(parent structSQL, from string, path map[string]bool, counter *int) []deleteStep {
+	var out []deleteStep
+	for _, table := range l.tables {
+		for _, field := range table.Fields.ForeignKeys() {
+			if field.ForeignKey() != parent.TableName() {
+				continue
+			}
+			*counter++
+			step := deleteStep{Index: *counter, Table: table.TableName(), Column: field.SQLName, Action: deleteAction(field.OnDelete()), From: from}
+			var dependents []deleteStep
+			if step.Action == "CASCADE" && table.HasID() && !path[table.TableName()] {
+				key := newKeyType(table.IDField())
+				path[table.TableName()] = true
+				dependents = l.deleteSteps(table, key.AsSQL(fmt.Sprintf("ids%d", step.Index)), path, counter)
+				delete(path, table.TableName())
+				if len(dependents) != 0 {
+					step.IDColumn, step.ScanIDs = table.IDField().SQLName, key.ScanIDs()
+				}
+			}
+			out = append(out, step)
+			out = append(out, dependents...)
+		}
+	}
+	return out
+}
+
+// deletePlanner is the data used by templateDeletePlan
+type deletePlanner struct {
+	structSQL // the deleted table
+	Steps     []deleteStep
+}
+
+func (l handler) newDeletePlanner(table structSQL) deletePlanner {
+	var counter int
+	key := newKeyType(table.IDField())
+	path := map[string]bool{table.TableName(): true}
+	return deletePlanner{structSQL: table, Steps: l.deleteSteps(table, key.AsSQL("ids"), path, &counter)}
+}
+
+var templateDeletePlan = template.Must(template.New("").Funcs(fnMap).Parse(`
+{{- $key := keyType .IDField -}}
+{{- $fn := print "Plan" .Name "Delete" -}}
+// {{ $fn }} reports the rows affected by deleting the {{ .Name }}s with the given ids,
+// following the ON DELETE actions of the foreign keys.
+// If 'perform' is true and the delete is not blocked, the rows are deleted,
+{{- if .SoftDeleteColumn }} bypassing the soft delete,{{ end }} and the cascades
+// are applied by the database.
+{{- if .HasOutbox }}
+// Only the deleted {{ .Name }}s are recorded in the outbox, not the cascaded rows.
+{{- end }}
+func {{ $fn }}({{ .CtxParam }}tx DB, ids []{{ $key }}, perform bool) (plan DeletePlan, err error) {
+	plan.Table = "{{ .TableName }}"
+	err = {{ .Call "QueryRow" $fn }}"SELECT count(*) FROM {{ .TableName }} WHERE {{ .IDField.SQLName }} = ANY($1)", {{ $key.AsSQL "ids" }}).Scan(&plan.Count)
+	if err != nil {
+		return plan, err
+	}
+	{{ range .Steps }}
+	// {{ .Table }}.{{ .Column }} : {{ .Action }}
+	{{- if .HasDependents }}
+	rows{{ .Index }}, err := {{ $.Call "Query" $fn }}"SELECT {{ .IDColumn }} FROM {{ .Table }} WHERE {{ .Column }} = ANY($1)", {{ .From }})
+	if err != nil {
+		return plan, err
+	}
+	ids{{ .Index }}, err := {{ .ScanIDs }}(rows{{ .Index }})
+	if err != nil {
+		return plan, err
+	}
+	plan.Effects = append(plan.Effects, DeleteEffect{Table: "{{ .Table }}", Column: "{{ .Column }}", Action: "{{ .Action }}", Count: len(ids{{ .Index }})})
+	{{- else }}
+	var count{{ .Index }} int
+	err = {{ $.Call "QueryRow" $fn }}"SELECT count(*) FROM {{ .Table }} WHERE {{ .Column }} = ANY($1)", {{ .From }}).Scan(&count{{ .Index }})
+	if err != nil {
+		return plan, err
+	}
+	plan.Effects = append(plan.Effects, DeleteEffect{Table: "{{ .Table }}", Column: "{{ .Column }}", Action: "{{ .Action }}", Count: count{{ .Index }}})
+	{{- end }}
+	{{ end }}
+	if perform && !plan.Blocked() {
+		{{- if .HasOutbox }}
+		rows, err := {{ .Call "Query" $fn }}"DELETE FROM {{ .TableName }} WHERE {{ .IDField.SQLName }} = ANY($1) RETURNING *", {{ $key.AsSQL "ids" }})
+		if err != nil {
+			return plan, err
+		}
+		items, err := Scan{{ .Name }}s(rows)
+		if err != nil {
+			return plan, err
+		}
+		{{ .WriteOutboxItems "DELETE" "items" "item" "nil" }}
+		return plan, err
+		{{- else }}
+		_, err = {{ .Call "Exec" $fn }}"DELETE FROM {{ .TableName }} WHERE {{ .IDField.SQLName }} = ANY($1)", {{ $key.AsSQL "ids" }})
+		{{- end }}
+	}
+	return plan, err
+}
+`))
@@ -0,0 +1,132 @@
+package crud
+
+import (
+	"text/template"
+
+	"github.com/benoitkugler/structgen/orm"
+)
+
+// relation is a table loaded by the graph loader, through
+// its foreign key to a table already loaded.
+type relation struct {
+	Table  structSQL
+	Key    orm.SQLField // foreign key of Table
+	Parent int          // index of the parent relation, or -1 for the root
+}
+
+// Field returns the name of the graph field storing the relation.
+func (r relation) Field() string { return r.Table.Name + "s" }
+
+// graphLoader loads the tables depending on a root table
+type graphLoader struct {
+	structSQL // the root
+	Relations []relation
+}
+
+// Constant returns the name of the constant selecting the relation.
+func (g graphLoader) Constant(r relation) string { return g.Name + "With" + r.Field() }
+
+// ParentConstant returns the constant selecting the parent of `r`,
+// or 0 for the root.
+func (g graphLoader) ParentConstant(r relation) string {
+	if r.Parent == -1 {
+		return "0"
+	}
+	return g.Constant(g.Relations[r.Parent])
+}
+
+// ParentField returns the graph field storing the parent of `r`.
+func (g graphLoader) ParentField(r relation) string {
+	if r.Parent == -1 {
+		return g.Name + "s"
+	}
+	return g.Relations[r.Parent].Field()
+}
+
+// newGraphLoader walks the foreign keys in reverse, starting from `root`,
+// and visiting each table at most once. When a table has several foreign keys
+// to the same parent, the first one is used.
+func newGraphLoader(root structSQL, tables []structSQL) graphLoader {
+	out := graphLoader{structSQL: root}
+	visited := map[string]bool{root.TableName(): true}
+	type node struct {
+		table     string
+		parentRel int
+	}
+	queue := []node{{root.TableName(), -1}}
+	for len(queue) != 0 {
+		current := queue[0]
+		queue = queue[1:]
+		for _, table := range tables {
+			if visited[table.TableName()] {
+				continue
+			}
+			for _, field := range table.Fields.ForeignKeys() {
+				if field.ForeignKey() != current.table {
+					continue
+				}
+				visited[table.TableName()] = true
+				out.Relations = append(out.Relations, relation{Table: table, Key: field, Parent: current.parentRel})
+				if table.HasID() { // only tables with ID may be referenced
+					queue = append(queue, node{table.TableName(), len(out.Relations) - 1})
+				}
+				break
+			}
+		}
+	}
+	return out
+}
+
+var templateGraphLoader = template.Must(template.New("").Funcs(fnMap).Parse(`
+{{- $key := keyType .IDField -}}
+// {{ .Name }}Relation selects the tables loaded by Load{{ .Name }}Graph.
+type {{ .Name }}Relation uint8
+
+const (
+	{{- range $i, $r := .Relations }}
+	{{ $.Constant $r }} {{ if eq $i 0 }}{{ $.Name }}Relation = iota + 1 {{ end }}// {{ $r.Table.TableName }}.{{ $r.Key.SQLName }}
+	{{- end }}
+)
+
+// {{ .Name }}Graph stores {{ .Name }}s and the items depending on them.
+type {{ .Name }}Graph struct {
+	{{ .Name }}s {{ .Name }}s
+	{{- range .Relations }}
+	{{ .Field }} {{ .Table.Name }}s
+	{{- end }}
+}
+
+// Load{{ .Name }}Graph loads the {{ .Name }}s with the given ids and the relations 
+// in 'include', with one query per relation. 
+// The relations required to reach the included ones are also loaded.
+func Load{{ .Name }}Graph({{ .CtxParam }}tx DB, ids []{{ $key }}, include ...{{ .Name }}Relation) (out {{ .Name }}Graph, err error) {
+	var with [{{ len .Relations }} + 1]bool
+	for _, rel := range include {
+		if rel == 0 || int(rel) >= len(with) {
+			return out, fmt.Errorf("invalid {{ .Name }}Relation %d", rel)
+		}
+		with[rel] = true
+	}
+
+	out.{{ .Name }}s, err = Select{{ .Name }}s({{ .CtxArg }}tx, ids...)
+	if err != nil {
+		return out, err
+	}
+	// a relation requires its parent, which comes first
+	parents := [...]{{ .Name }}Relation{0, {{ range .Relations }}{{ $.ParentConstant . }}, {{ end }}}
+	for i := len(with) - 1; i > 0; i-- {
+		if with[i] {
+			with[parents[i]] = true
+		}
+	}
+	{{ range .Relations }}
+	if with[{{ $.Constant . }}] {
+		out.{{ .Field }}, err = Select{{ .Table.Name }}sBy{{ .Key.GoName }}s({{ $.CtxArg }}tx, out.{{ $.ParentField . }}.IDs()...)
+		if err != nil {
+			return out, err
+		}
+	}
+	{{- end }}
+	return out, nil
+}
+`))
@@ -1,5 +1,7 @@
 package crud
 
+import "text/template"
+
 // code included in the generated CRUD Go code
 const utils = `
 func loadJSON(out interface{}, src interface{}) error {
@@ -20,17 +22,37 @@ func dumpJSON(s interface{}) (driver.Value, error) {
 	}
 	return driver.Value(string(b)), nil
 }
+`
+
+// included when one table uses optimistic locking
+const errConcurrentUpdate = `
+// ErrConcurrentUpdate is returned by the Update methods when the row
+// has been modified (or deleted) since it was read, that is
+// when its version column does not match the one of the updated item.
+type ErrConcurrentUpdate struct {
+	Table   string
+	Version int64 // the expected version
+}
 
-// Set is a set of IDs.
-type Set map[int64]bool
+func (err ErrConcurrentUpdate) Error() string {
+	return fmt.Sprintf("concurrent update on table %s: row with version %d not found", err.Table, err.Version)
+}
+`
 
-func NewSet() Set {
-	return map[int64]bool{}
+// templateKeyHelpers generates the Set and IDs helpers
+// for a key type. It is included once per key type
+// found in the tables.
+var templateKeyHelpers = template.Must(template.New("").Parse(`
+// {{ .Set }} is a set of {{ .IDs }}.
+type {{ .Set }} map[{{ . }}]bool
+
+func New{{ .Set }}() {{ .Set }} {
+	return map[{{ . }}]bool{}
 }
 
-// NewSetFromSlice returns a set of unique IDs
-func NewSetFromSlice(keys []int64) Set {
-	out := make(Set, len(keys))
+// New{{ .Set }}FromSlice returns a set of unique IDs
+func New{{ .Set }}FromSlice(keys []{{ . }}) {{ .Set }} {
+	out := make({{ .Set }}, len(keys))
 	for _, key := range keys {
 		out[key] = true
 	}
@@ -38,41 +60,41 @@ func NewSetFromSlice(keys []int64) Set {
 }
 
 // Keys return the IDs contained in the set, as a slice.
-func (s Set) Keys() []int64 {
-	out := make([]int64, 0, len(s))
+func (s {{ .Set }}) Keys() []{{ . }} {
+	out := make([]{{ . }}, 0, len(s))
 	for k := range s {
 		out = append(out, k)
 	}
 	return out
 }
 
-func (s Set) Has(key int64) bool {
+func (s {{ .Set }}) Has(key {{ . }}) bool {
 	_, has := s[key]
 	return has
 }
 
-func (s Set) Add(key int64) {
+func (s {{ .Set }}) Add(key {{ . }}) {
 	s[key] = true
 }
 
-type IDs []int64
+type {{ .IDs }} []{{ . }}
 
-func (ids IDs) AsSQL() pq.Int64Array {
-	return pq.Int64Array(ids)
+func (ids {{ .IDs }}) AsSQL() {{ .SQLArrayType }} {
+	return {{ .AsSQL "ids" }}
 }
 
-func (ids IDs) AsSet() Set {
-	return NewSetFromSlice(ids)
+func (ids {{ .IDs }}) AsSet() {{ .Set }} {
+	return New{{ .Set }}FromSlice(ids)
 }
 
-// ScanIDs scans the result of a query returning a
+// {{ .ScanIDs }} scans the result of a query returning a
 // list of IDs.
-func ScanIDs(rs *sql.Rows) (IDs, error) {
+func {{ .ScanIDs }}(rs *sql.Rows) ({{ .IDs }}, error) {
 	defer rs.Close()
-	ints := make(IDs, 0, 16)
+	ints := make({{ .IDs }}, 0, 16)
 	var err error
 	for rs.Next() {
-		var s int64
+		var s {{ . }}
 		if err = rs.Scan(&s); err != nil {
 			return nil, err
 		}
@@ -83,4 +105,4 @@ func ScanIDs(rs *sql.Rows) (IDs, error) {
 	}
 	return ints, nil
 }
-`
+`))
@@ -0,0 +1,156 @@
+package crud
+
+import (
+	"bytes"
+	"fmt"
+	"go/types"
+	"strings"
+	"text/template"
+
+	"github.com/benoitkugler/structgen/loader"
+	"github.com/benoitkugler/structgen/orm"
+	"github.com/benoitkugler/structgen/orm/jsonsql"
+	"github.com/benoitkugler/structgen/orm/sqltypes"
+)
+
+// jsonPath is a typed accessor to a scalar value
+// nested in a JSONB column.
+type jsonPath struct {
+	Name   string // concatenated Go field names
+	Column columnType
+	SQL    string // expression extracting the value, with the proper cast
+	keys   []string
+}
+
+func newJSONPath(column string, path jsonsql.Path, qualifier types.Qualifier) jsonPath {
+	expr := column
+	for i, key := range path.Keys {
+		op := "->"
+		if i == len(path.Keys)-1 {
+			op = "->>" // as text
+		}
+		expr += op + "'" + strings.ReplaceAll(key, "'", "''") + "'"
+	}
+	switch {
+	case path.Kind == jsonsql.Number:
+		expr = "(" + expr + ")::numeric"
+	case path.Kind == jsonsql.Boolean:
+		expr = "(" + expr + ")::boolean"
+	case isStruct(path.Go): // time-like values are JSONed as strings
+		expr = "(" + expr + ")::timestamptz"
+	}
+	return jsonPath{
+		Name:   strings.Join(path.GoNames, ""),
+		Column: columnTypeOf(path.Go, types.TypeString(path.Go, qualifier), sqltypes.NoCodec),
+		SQL:    expr,
+		keys:   path.Keys,
+	}
+}
+
+// Dotted returns the path of the value, such as options.a.b
+func (p jsonPath) Dotted(column string) string {
+	return column + "." + strings.Join(p.keys, ".")
+}
+
+// Filter returns the Go expression of the JSON object
+// containing `value` at the path.
+func (p jsonPath) Filter(value string) string {
+	for i := len(p.keys) - 1; i >= 0; i-- {
+		value = fmt.Sprintf("map[string]interface{}{%q: %s}", p.keys[i], value)
+	}
+	return value
+}
+
+func isStruct(typ types.Type) bool {
+	_, ok := typ.Underlying().(*types.Struct)
+	return ok
+}
+
+// jsonColumn is a JSONB column whose content is a struct.
+type jsonColumn struct {
+	Field orm.SQLField
+	Paths []jsonPath
+}
+
+// jsonColumns returns the JSONB columns with nested scalar values.
+func (m structSQL) jsonColumns() []jsonColumn {
+	qualifier := func(pkg *types.Package) string {
+		if pkg.Name() == m.packageName {
+			return ""
+		}
+		return pkg.Name()
+	}
+	var out []jsonColumn
+	for _, field := range m.Fields {
+		if field.Type.Type != sqltypes.JSONB || field.Type.JSON == nil {
+			continue
+		}
+		col := jsonColumn{Field: field}
+		for _, path := range jsonsql.Paths(field.Type.JSON) {
+			col.Paths = append(col.Paths, newJSONPath(field.SQLName, path, qualifier))
+		}
+		if len(col.Paths) != 0 {
+			out = append(out, col)
+		}
+	}
+	return out
+}
+
+var templateJSONPaths = template.Must(template.New("").Funcs(fnMap).Parse(`
+{{- range .Columns }}{{ $column := . }}
+// {{ $.Table.Name }}{{ .Field.GoName }}Path lists the values nested in the JSON column {{ .Field.SQLName }},
+// to be used with Query{{ $.Table.Name }}s.
+var {{ $.Table.Name }}{{ .Field.GoName }}Path = struct {
+	{{ range .Paths }}{{ .Name }} {{ .Column.Name }}
+	{{ end }}
+}{
+	{{ range .Paths }}{{ .Name }}: {{ printf "%q" .SQL }},
+	{{ end }}
+}
+{{ range .Paths }}
+{{- $funcName := print "Select" $.Table.Name "sWhere" $column.Field.GoName .Name "Eq" }}
+// {{ $funcName }} returns the items whose {{ .Dotted $column.Field.SQLName }} equals 'value'.
+// It uses the containment operator @>, which may be supported by a GIN index.
+func {{ $funcName }}({{ $.Table.CtxParam }}tx DB, value {{ .Column.GoType }}) ({{ $.Table.Name }}s, error) {
+	filter, err := json.Marshal({{ .Filter "value" }})
+	if err != nil {
+		return nil, err
+	}
+	rows, err := {{ $.Table.Call "Query" $funcName }}"SELECT * FROM {{ snake $.Table.Name }}s WHERE {{ $column.Field.SQLName }} @> $1{{ $.Table.AndNotDeleted }}", string(filter))
+	if err != nil {
+		return nil, err
+	}
+	return Scan{{ $.Table.Name }}s(rows)
+}
+{{ end }}
+{{- end }}
+`))
+
+// renderJSONPaths returns the accessors of the values nested in the
+// JSONB columns, and the column types they require.
+func (m structSQL) renderJSONPaths() []loader.Declaration {
+	columns := m.jsonColumns()
+	if len(columns) == 0 {
+		return nil
+	}
+	var decls []loader.Declaration
+	for _, column := range columns {
+		for _, path := range column.Paths {
+			var code bytes.Buffer
+			if err := templateColumnType.Execute(&code, path.Column); err != nil {
+				panic(err)
+			}
+			decls = append(decls, loader.Declaration{Id: "column_type_" + path.Column.Name, Content: code.String()})
+		}
+	}
+
+	var code bytes.Buffer
+	err := templateJSONPaths.Execute(&code, struct {
+		Table   structSQL
+		Columns []jsonColumn
+	}{m, columns})
+	if err != nil {
+		panic(err)
+	}
+	return append(decls, loader.Declaration{Id: "json_paths_" + m.Name, Content: code.String()})
+}
@@ -0,0 +1,99 @@
+package crud
+
+import (
+	"text/template"
+
+	"github.com/benoitkugler/structgen/orm"
+)
+
+// linkLoader navigates a many-to-many relation through
+// the link table, from the `Source` column to the `Target` one.
+type linkLoader struct {
+	structSQL // the link table
+	FuncName  string
+	Source    orm.SQLField
+	Target    orm.SQLField
+	Targets   structSQL // the table refered by Target
+}
+
+// IsLink returns `true` for tables without ID and with at least
+// two foreign keys.
+func (m structSQL) IsLink() bool {
+	return !m.HasID() && len(m.Fields.ForeignKeys()) >= 2
+}
+
+// linkLoaders returns the loaders for each pair of foreign keys
+// of the link table `m`. Foreign keys refering to tables unknown to `tables`
+// (indexed by SQL name) or without ID are ignored.
+// `usedNames` is used to disambiguate the function names.
+func (m structSQL) linkLoaders(tables map[string]structSQL, usedNames map[string]bool) []linkLoader {
+	if !m.IsLink() {
+		return nil
+	}
+	var out []linkLoader
+	fks := m.Fields.ForeignKeys()
+	for _, source := range fks {
+		sourceTable, ok := tables[source.ForeignKey()]
+		if !ok {
+			continue
+		}
+		for _, target := range fks {
+			targetTable, ok := tables[target.ForeignKey()]
+			if target.SQLName == source.SQLName || !ok || !targetTable.HasID() {
+				continue
+			}
+			name := "Load" + targetTable.Name + "sFor" + sourceTable.Name + "s"
+			if usedNames[name] {
+				name += "Via" + m.Name + "By" + source.GoName
+			}
+			usedNames[name] = true
+			out = append(out, linkLoader{structSQL: m, FuncName: name, Source: source, Target: target, Targets: targetTable})
+		}
+	}
+	return out
+}
+
+var templateLinkLoader = template.Must(template.New("").Funcs(fnMap).Parse(`
+{{- $source := keyType .Source -}}
+{{- $target := .Targets.IDField -}}
+// {{ .FuncName }} returns the {{ .Targets.Name }}s linked to each of the given '{{ .Source.GoName }}' 
+// through the table {{ .TableName }}, with one join query.
+func {{ .FuncName }}({{ .CtxParam }}tx DB, ids ...{{ $source }}) (out map[{{ $source }}]{{ .Targets.Name }}s, err error) {
+	rows, err := {{ .Call "Query" .FuncName }}` + "`" + `SELECT {{ .TableName }}.{{ .Source.SQLName }}, {{ .Targets.TableName }}.*
+		FROM {{ .Targets.TableName }} JOIN {{ .TableName }} ON {{ .TableName }}.{{ .Target.SQLName }} = {{ .Targets.TableName }}.{{ $target.SQLName }}
+		WHERE {{ .TableName }}.{{ .Source.SQLName }} = ANY($1)
+		{{- with .SoftDeleteColumn }} AND {{ $.TableName }}.{{ . }} IS NULL{{ end }}
+		{{- with .Targets.SoftDeleteColumn }} AND {{ $.Targets.TableName }}.{{ . }} IS NULL{{ end }}` + "`" + `, {{ $source.AsSQL "ids" }})
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		errClose := rows.Close()
+		if err == nil {
+			err = errClose
+		}
+	}()
+
+	out = make(map[{{ $source }}]{{ .Targets.Name }}s, len(ids))
+	for rows.Next() {
+		var (
+			source {{ $source }}
+			s {{ .Targets.Name }}
+		)
+		err = rows.Scan(&source, {{ range .Targets.Fields }}{{ .ScanArg "s" }},{{ end }})
+		if err != nil {
+			return nil, err
+		}
+		targets := out[source]
+		if targets == nil {
+			targets = make({{ .Targets.Name }}s)
+			out[source] = targets
+		}
+		targets[s.{{ $target.GoName }}] = s
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+`))
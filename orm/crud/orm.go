@@ -4,6 +4,8 @@ import (
 	"bytes"
 	"fmt"
 	"go/types"
+	"sort"
+	"strings"
 
 	"github.com/benoitkugler/structgen/loader"
 	"github.com/benoitkugler/structgen/orm"
@@ -15,11 +17,155 @@ var _ loader.Handler = &handler{} // interface conformity
 type structSQL struct {
 	packageName string
 	orm.GoSQLTable
+	options Options
 }
 
 type structSQLTest struct {
 	packageName string
 	orm.GoSQLTable
+	options Options
+}
+
+// LinkKeys returns the columns identifying one row of a table
+// without ID : the composite primary key if any, or the foreign keys.
+func (m structSQL) LinkKeys() []orm.SQLField {
+	if m.HasCompositeKey() {
+		return m.Fields.Primary()
+	}
+	return m.Fields.ForeignKeys()
+}
+
+// assignment describes the columns written by
+// an INSERT or UPDATE statement.
+type assignment struct {
+	Columns string // comma separated SQL names
+	Values  string // comma separated placeholders or expressions
+	Args    string // Go arguments, each one starting with a comma
+
+	// for updates with optimistic locking
+	VersionCheck  string // additionnal WHERE condition
+	VersionArg    string // Go argument, starting with a comma
+	VersionGoName string // Go field name of the version column
+}
+
+// newAssignment uses placeholders starting at `firstParam` for `fs`,
+// and now() for the audit columns. The soft delete column is only
+// written by the Delete and Restore functions.
+// For updates, the version column is incremented and checked.
+func newAssignment(fs []orm.SQLField, firstParam int, isUpdate bool) assignment {
+	var columns, values []string
+	var out assignment
+	for _, f := range fs {
+		switch {
+		case f.IsSoftDelete():
+			continue
+		case f.IsCreatedAt() && isUpdate:
+			continue
+		case f.IsCreatedAt(), f.IsUpdatedAt():
+			values = append(values, "now()")
+		case f.IsVersion() && isUpdate:
+			values = append(values, f.SQLName+" + 1")
+			out.VersionCheck = fmt.Sprintf(" AND %s = $%%d", f.SQLName)
+			out.VersionArg = ",item." + f.GoName
+			out.VersionGoName = f.GoName
+		default:
+			values = append(values, fmt.Sprintf("$%d", firstParam))
+			out.Args += "," + f.ValueArg("item")
+			firstParam++
+		}
+		columns = append(columns, f.SQLName)
+	}
+	if out.VersionCheck != "" { // the version is the last argument
+		out.VersionCheck = fmt.Sprintf(out.VersionCheck, firstParam)
+	}
+	out.Columns, out.Values = strings.Join(columns, ","), strings.Join(values, ",")
+	return out
+}
+
+// CopyFields returns the columns sent by InsertMany :
+// the audit columns are set by their default value, now().
+func (m structSQL) CopyFields() []orm.SQLField {
+	var out []orm.SQLField
+	for _, f := range m.Fields.Exported() {
+		if !f.IsCreatedAt() && !f.IsUpdatedAt() {
+			out = append(out, f)
+		}
+	}
+	return out
+}
+
+// InsertAssignment returns the columns set by Insert.
+func (m structSQL) InsertAssignment() assignment {
+	return newAssignment(m.Fields.Exported().NoId(), 1, false)
+}
+
+// UpdateAssignment returns the columns set by Update,
+// whose first placeholders are used by the primary key.
+func (m structSQL) UpdateAssignment() assignment {
+	return newAssignment(m.Fields.Exported().NotPrimary(), len(m.Fields.Primary())+1, true)
+}
+
+// HasUpdate returns `true` if an Update method is generated,
+// that is if the table has a primary key and some other columns to set.
+func (m structSQL) HasUpdate() bool {
+	return (m.HasID() || m.HasCompositeKey()) && m.UpdateAssignment().Columns != ""
+}
+
+// WhereNotDeleted returns a WHERE clause excluding the
+// soft deleted rows, or an empty string.
+func (m structSQL) WhereNotDeleted() string {
+	if col := m.SoftDeleteColumn(); col != "" {
+		return " WHERE " + col + " IS NULL"
+	}
+	return ""
+}
+
+// AndNotDeleted is the same as WhereNotDeleted, for a WHERE clause
+// with other conditions.
+func (m structSQL) AndNotDeleted() string {
+	if col := m.SoftDeleteColumn(); col != "" {
+		return " AND " + col + " IS NULL"
+	}
+	return ""
+}
+
+// keyType is the Go type of a primary or foreign key,
+// used to name the generated IDs and Set helpers.
+// The historical int64 keys use the names IDs and Set.
+type keyType string
+
+func newKeyType(field orm.SQLField) keyType { return keyType(field.ValueGoType()) }
+
+func (k keyType) suffix() string {
+	if k == "int64" {
+		return ""
+	}
+	name := string(k)
+	if i := strings.LastIndexByte(name, '.'); i != -1 {
+		name = name[i+1:]
+	}
+	return strings.ToUpper(name[:1]) + name[1:]
+}
+
+func (k keyType) IDs() string     { return "IDs" + k.suffix() }
+func (k keyType) Set() string     { return "Set" + k.suffix() }
+func (k keyType) ScanIDs() string { return "ScanIDs" + k.suffix() }
+
+// SQLArrayType is the type returned by AsSQL
+func (k keyType) SQLArrayType() string {
+	if k == "int64" {
+		return "pq.Int64Array"
+	}
+	return "driver.Valuer"
+}
+
+// AsSQL returns the code converting the slice `varName`
+// to a SQL array.
+func (k keyType) AsSQL(varName string) string {
+	if k == "int64" {
+		return fmt.Sprintf("pq.Int64Array(%s)", varName)
+	}
+	return fmt.Sprintf("pq.Array(%s)", varName)
 }
 
 // return `true` is typ package name is the current package
@@ -34,22 +180,31 @@ func (st structSQL) canImplementMethod(field string, typ types.Type) (string, bo
 }
 
 func (m structSQL) Render() []loader.Declaration {
-	args := m.GoSQLTable
-
 	tmpl := templateStructLink
-	if args.HasID() {
+	if m.HasID() {
 		tmpl = templateStructWithID
 	}
 
 	var out bytes.Buffer
-	if err := templateScan.Execute(&out, args); err != nil {
+	if err := templateScan.Execute(&out, m); err != nil {
 		panic(err)
 	}
-	if err := tmpl.Execute(&out, args); err != nil {
+	if err := tmpl.Execute(&out, m); err != nil {
 		panic(err)
 	}
+	if m.HasCompositeKey() {
+		if err := templateStructWithKey.Execute(&out, m); err != nil {
+			panic(err)
+		}
+	}
+	if m.HasUpdate() {
+		if err := templateUpdate.Execute(&out, m); err != nil {
+			panic(err)
+		}
+	}
 
 	decls := []loader.Declaration{{Id: m.Id(), Content: out.String()}}
+	decls = append(decls, m.renderQuery()...)
 
 	// generate the value interface method
 	for _, field := range m.Fields {
@@ -82,11 +237,11 @@ func (m structSQL) Render() []loader.Declaration {
 				switch arr.Element {
 				case "boolean":
 					pqType = "pq.BoolArray"
-				case "integer":
+				case "smallint", "integer", "bigint":
 					pqType = "pq.Int64Array"
-				case "real":
+				case "real", "double precision":
 					pqType = "pq.Float64Array"
-				case "varchar":
+				case sqltypes.SQLVarchar:
 					pqType = "pq.StringArray"
 				}
 				decls = append(decls, loader.Declaration{
@@ -115,7 +270,7 @@ func (m structSQL) Render() []loader.Declaration {
 }
 
 func (m structSQLTest) Render() []loader.Declaration {
-	args := m.GoSQLTable
+	args := structSQL{m.packageName, m.GoSQLTable, m.options}
 	var out bytes.Buffer
 	if err := templateTest.Execute(&out, args); err != nil {
 		panic(err)
@@ -127,31 +282,97 @@ func (m structSQLTest) Render() []loader.Declaration {
 type handler struct {
 	PackageName string
 
-	uniqueConstraints map[string][]string // table name -> unique cols
-	tables            []structSQL
+	uniqueKeys map[string][][]string // table name -> sets of unique columns
+	tables     []structSQL
+
+	Options
+}
 
-	IsTest bool
+func NewHandler(packageName string, options Options) *handler {
+	return &handler{PackageName: packageName, Options: options, uniqueKeys: make(map[string][][]string)}
 }
 
-func NewHandler(packageName string, isTest bool) *handler {
-	return &handler{PackageName: packageName, IsTest: isTest, uniqueConstraints: make(map[string][]string)}
+// keyTypes returns the types used by primary and foreign keys,
+// starting by int64, which is always included.
+func (l handler) keyTypes() []keyType {
+	set := map[keyType]bool{}
+	for _, table := range l.tables {
+		if table.HasID() {
+			set[newKeyType(table.IDField())] = true
+		}
+		for _, field := range table.Fields.ForeignKeys() {
+			set[newKeyType(field)] = true
+		}
+	}
+	delete(set, "int64")
+	out := []keyType{"int64"}
+	for k := range set {
+		out = append(out, k)
+	}
+	sort.Slice(out[1:], func(i, j int) bool { return out[i+1] < out[j+1] })
+	return out
+}
+
+// hasVersion returns `true` if one table uses optimistic locking
+func (l handler) hasVersion() bool {
+	for _, table := range l.tables {
+		if table.HasUpdate() && table.UpdateAssignment().VersionCheck != "" {
+			return true
+		}
+	}
+	return false
+}
+
+// hasDeletePlan returns `true` if one table is refered by foreign keys
+func (l handler) hasDeletePlan() bool {
+	for _, table := range l.tables {
+		if table.HasID() && len(l.newDeletePlanner(table).Steps) != 0 {
+			return true
+		}
+	}
+	return false
 }
 
 func (l handler) Header() string {
-	var dbInterface string
+	var header string
 	if !l.IsTest {
-		dbInterface = utils + `
+		if l.hasVersion() {
+			header += errConcurrentUpdate
+		}
+		if l.hasDeletePlan() {
+			header += deletePlan
+		}
+		if l.Outbox {
+			var outbox bytes.Buffer
+			if err := templateOutbox.Execute(&outbox, l.WithContext); err != nil {
+				panic(err)
+			}
+			header += outbox.String()
+		}
+		var keyHelpers bytes.Buffer
+		for _, key := range l.keyTypes() {
+			if err := templateKeyHelpers.Execute(&keyHelpers, key); err != nil {
+				panic(err)
+			}
+		}
+		var queryHelpers bytes.Buffer
+		if err := templateQueryUtils.Execute(&queryHelpers, l.WithContext); err != nil {
+			panic(err)
+		}
+		tables := make([]orm.GoSQLTable, len(l.tables))
+		for i, table := range l.tables {
+			tables[i] = table.GoSQLTable
+		}
+		header += utils + orm.CodecHelpers(tables) + queryHelpers.String() + keyHelpers.String() + `
 		type scanner interface {
 			Scan(...interface{}) error
 		}
-
-		// DB groups transaction like objects
-		type DB interface {
-			Exec(query string, args ...interface{}) (sql.Result, error)
-			Query(query string, args ...interface{}) (*sql.Rows, error)
-			QueryRow(query string, args ...interface{}) *sql.Row 
-			Prepare(query string) (*sql.Stmt, error)
-		}`
+		`
+		if l.WithContext {
+			header += dbInterfaceContext
+		} else {
+			header += dbInterfaceNoContext
+		}
 	}
 
 	return fmt.Sprintf(`
@@ -163,17 +384,48 @@ func (l handler) Header() string {
 
 	%s 
 
-	`, l.PackageName, dbInterface)
+	`, l.PackageName, header)
 }
 
 func (l handler) Footer() string {
 	var out bytes.Buffer
+	uniqueColumns := make(map[string][]string)
+	for table, keys := range l.uniqueKeys {
+		for _, key := range keys {
+			if len(key) == 1 {
+				uniqueColumns[table] = append(uniqueColumns[table], key[0])
+			}
+		}
+	}
+	tables := make(map[string]structSQL, len(l.tables))
+	for _, table := range l.tables {
+		tables[table.TableName()] = table
+	}
+	usedNames := map[string]bool{}
 	for _, table := range l.tables {
-		table.SetUniqueColumns(l.uniqueConstraints)
+		table.SetUniqueColumns(uniqueColumns)
 		if err := templateSelectBy.Execute(&out, table); err != nil {
 			panic(err)
 		}
+		if err := templateUpsert.Execute(&out, table.upserts(l.uniqueKeys[table.Name])); err != nil {
+			panic(err)
+		}
+		for _, loader := range table.linkLoaders(tables, usedNames) {
+			if err := templateLinkLoader.Execute(&out, loader); err != nil {
+				panic(err)
+			}
+		}
 		if table.HasID() { // the lookup methods are only valid for link tables
+			if graph := newGraphLoader(table, l.tables); len(graph.Relations) != 0 {
+				if err := templateGraphLoader.Execute(&out, graph); err != nil {
+					panic(err)
+				}
+			}
+			if planner := l.newDeletePlanner(table); len(planner.Steps) != 0 {
+				if err := templateDeletePlan.Execute(&out, planner); err != nil {
+					panic(err)
+				}
+			}
 			continue
 		}
 		if err := templateStructLinkToLookup.Execute(&out, table); err != nil {
@@ -190,22 +442,39 @@ func (l *handler) HandleType(typ types.Type) loader.Type {
 	}
 	var decl loader.Type
 	if l.IsTest {
-		decl = structSQLTest{l.PackageName, item}
+		decl = structSQLTest{l.PackageName, item, l.Options}
 	} else {
-		table := structSQL{l.PackageName, item}
+		table := structSQL{l.PackageName, item, l.Options}
 		l.tables = append(l.tables, table)
 		decl = table
+		// unique indexes declared by tags
+		for _, field := range item.Fields {
+			if index, has, err := field.Index(item.Name); has && err == nil && index.UniqueColumns() != nil { // errors are reported by the creation handler
+				l.uniqueKeys[item.Name] = append(l.uniqueKeys[item.Name], index.UniqueColumns())
+			}
+		}
 	}
 	return decl
 }
 
 func (l handler) HandleComment(comment loader.Comment) error {
-	if comment.Tag != "sql" { // ignored
+	var columns []string
+	switch comment.Tag {
+	case "sql":
+		columns = orm.UniqueConstraintColumns(comment)
+	case "sql_index":
+		index, err := orm.NewIndex(comment, nil)
+		if err != nil {
+			// enums values are not resolved here : the error
+			// is reported by the creation handler
+			return nil
+		}
+		columns = index.UniqueColumns()
+	default: // ignored
 		return nil
 	}
-	column := orm.IsUniqueConstraint(comment)
-	if column != "" { // we have a unique field
-		l.uniqueConstraints[comment.TypeName] = append(l.uniqueConstraints[comment.TypeName], column)
+	if columns != nil { // we have a unique key
+		l.uniqueKeys[comment.TypeName] = append(l.uniqueKeys[comment.TypeName], columns)
 	}
 	return nil
 }
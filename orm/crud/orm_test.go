@@ -1,10 +1,15 @@
 package crud
 
 import (
+	"bytes"
+	"go/types"
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
+	"text/template"
 
+	"github.com/benoitkugler/structgen/internal/ormtest"
 	"github.com/benoitkugler/structgen/loader"
 )
 
@@ -19,7 +24,7 @@ func TestMain(t *testing.T) {
 	if err != nil {
 		t.Fatal(err)
 	}
-	typeHandler := NewHandler("skldl", false)
+	typeHandler := NewHandler("skldl", Options{})
 	decls, err := loader.WalkFile(fullPath, pkg, typeHandler)
 	if err != nil {
 		t.Fatal(err)
@@ -30,3 +35,284 @@ func TestMain(t *testing.T) {
 		t.Fatal(err)
 	}
 }
+
+func newTable(name string, fieldNames []string, typs []types.Type, tags []string) structSQL {
+	return structSQL{"test", ormtest.NewTable(name, fieldNames, typs, tags), Options{}}
+}
+
+func TestAuditColumns(t *testing.T) {
+	int64T, stringT := types.Typ[types.Int64], types.Typ[types.String]
+	table := newTable("Post",
+		[]string{"Id", "Title", "CreatedAt", "UpdatedAt", "DeletedAt"},
+		[]types.Type{int64T, stringT, stringT, stringT, types.NewPointer(stringT)},
+		[]string{"", "", `sql:",created_at"`, `sql:",updated_at"`, `sql:",soft_delete"`},
+	)
+	insert := table.InsertAssignment()
+	if insert.Columns != "Title,CreatedAt,UpdatedAt" || insert.Values != "$1,now(),now()" || insert.Args != ",item.Title" {
+		t.Fatal(insert)
+	}
+	update := table.UpdateAssignment()
+	if update.Columns != "Title,UpdatedAt" || update.Values != "$2,now()" || update.Args != ",item.Title" {
+		t.Fatal(update)
+	}
+	if table.WhereNotDeleted() != " WHERE DeletedAt IS NULL" {
+		t.Fatal(table.WhereNotDeleted())
+	}
+	if fields := table.CopyFields(); len(fields) != 3 || fields[2].GoName != "DeletedAt" {
+		t.Fatal(fields)
+	}
+}
+
+func TestKeyType(t *testing.T) {
+	for _, test := range []struct {
+		key keyType
+		ids string
+	}{
+		{"int64", "IDs"},
+		{"string", "IDsString"},
+		{"uuid.UUID", "IDsUUID"},
+	} {
+		if test.key.IDs() != test.ids {
+			t.Fatal(test.key.IDs())
+		}
+	}
+}
+
+func TestVersion(t *testing.T) {
+	int64T, stringT := types.Typ[types.Int64], types.Typ[types.String]
+	table := newTable("Post",
+		[]string{"Id", "Version", "Title"},
+		[]types.Type{int64T, int64T, stringT},
+		[]string{"", `sql:",version"`, ""},
+	)
+	if table.Fields[1].SQLName != "Version" {
+		t.Fatal(table.Fields[1])
+	}
+	update := table.UpdateAssignment()
+	if update.Values != "Version + 1,$2" || update.VersionCheck != " AND Version = $3" || update.VersionArg != ",item.Version" {
+		t.Fatal(update)
+	}
+}
+
+func TestColumnType(t *testing.T) {
+	table := newTable("Post",
+		[]string{"Id", "Title", "Tags"},
+		[]types.Type{types.Typ[types.Int64], types.Typ[types.String], types.NewSlice(types.Typ[types.String])},
+		[]string{"", "", ""},
+	)
+	expected := []columnType{
+		{GoType: "int64", Name: "ColumnInt64"},
+		{GoType: "string", Name: "ColumnString", IsText: true},
+		{GoType: "interface{}", Name: "ColumnAny"},
+	}
+	for i, field := range table.Fields {
+		if ct := newColumnType(field); ct != expected[i] {
+			t.Fatalf("expected %v, got %v", expected[i], ct)
+		}
+	}
+	decls := table.renderQuery()
+	if len(decls) != 4 || decls[3].Id != "query_Post" {
+		t.Fatal(decls)
+	}
+}
+
+func TestJSONPaths(t *testing.T) {
+	pkg := types.NewPackage("test", "test")
+	theme := types.NewNamed(types.NewTypeName(0, pkg, "Theme", nil), types.NewStruct([]*types.Var{
+		types.NewField(0, pkg, "Color", types.Typ[types.String], false),
+	}, []string{`json:"color"`}), nil)
+	options := types.NewNamed(types.NewTypeName(0, pkg, "Options", nil), types.NewStruct([]*types.Var{
+		types.NewField(0, pkg, "Size", types.Typ[types.Int], false),
+		types.NewField(0, pkg, "Visible", types.Typ[types.Bool], false),
+		types.NewField(0, pkg, "Tags", types.NewSlice(types.Typ[types.String]), false),
+		types.NewField(0, pkg, "Theme", theme, false),
+	}, []string{`json:"size"`, "", "", `json:"theme"`}), nil)
+	table := newTable("User",
+		[]string{"Id", "Opts"},
+		[]types.Type{types.Typ[types.Int64], options},
+		[]string{"", `json:"opts"`},
+	)
+	columns := table.jsonColumns()
+	if len(columns) != 1 || len(columns[0].Paths) != 3 {
+		t.Fatal(columns)
+	}
+	expected := []jsonPath{
+		{Name: "Size", Column: columnType{GoType: "int", Name: "ColumnInt"}, SQL: "(opts->>'size')::numeric"},
+		{Name: "Visible", Column: columnType{GoType: "bool", Name: "ColumnBool"}, SQL: "(opts->>'Visible')::boolean"},
+		{Name: "ThemeColor", Column: columnType{GoType: "string", Name: "ColumnString", IsText: true}, SQL: "opts->'theme'->>'color'"},
+	}
+	for i, path := range columns[0].Paths {
+		if e := expected[i]; path.Name != e.Name || path.Column != e.Column || path.SQL != e.SQL {
+			t.Fatalf("expected %v, got %v", e, path)
+		}
+	}
+	if filter := columns[0].Paths[2].Filter("v"); filter != `map[string]interface{}{"theme": map[string]interface{}{"color": v}}` {
+		t.Fatal(filter)
+	}
+	decls := table.renderJSONPaths()
+	if last := decls[len(decls)-1]; last.Id != "json_paths_User" {
+		t.Fatal(decls)
+	}
+}
+
+func TestUpserts(t *testing.T) {
+	int64T, stringT := types.Typ[types.Int64], types.Typ[types.String]
+	table := newTable("Post",
+		[]string{"Id", "Title", "Slug", "Version"},
+		[]types.Type{int64T, stringT, stringT, types.Typ[types.Int]},
+		[]string{"", `json:"title"`, `json:"slug"`, `json:"version" sql:",version"`},
+	)
+	upserts := table.upserts([][]string{{"slug"}, {"slug"}, {"unknown"}}).Upserts
+	if len(upserts) != 1 {
+		t.Fatal(upserts)
+	}
+	u := upserts[0]
+	if u.FuncName != "UpsertPostBySlug" || u.Set != "title = EXCLUDED.title, version = posts.version + 1" {
+		t.Fatal(u)
+	}
+
+	link := newTable("UserGroup",
+		[]string{"IdUser", "IdGroup"},
+		[]types.Type{int64T, int64T},
+		[]string{`json:"id_user"`, `json:"id_group"`},
+	)
+	if upserts = link.upserts(nil).Upserts; len(upserts) != 0 {
+		t.Fatal("the conflict target requires a unique constraint", upserts)
+	}
+	upserts = link.upserts([][]string{{"id_group", "id_user"}}).Upserts
+	if len(upserts) != 1 || upserts[0].FuncName != "UpsertUserGroup" || upserts[0].TargetColumns() != "id_user, id_group" {
+		t.Fatal(upserts)
+	}
+}
+
+func TestContext(t *testing.T) {
+	table := newTable("Post", []string{"Id"}, []types.Type{types.Typ[types.Int64]}, []string{""})
+	if table.CtxParam() != "" || table.Call("QueryRow", "SelectPost") != "tx.QueryRow(" {
+		t.Fatal("unexpected context")
+	}
+	table.options.WithContext = true
+	if table.CtxParam() != "ctx context.Context, " || table.Call("QueryRow", "SelectPost") != `queryRowContext(ctx, tx, "SelectPost", ` {
+		t.Fatal(table.Call("QueryRow", "SelectPost"))
+	}
+}
+
+func TestLinkLoaders(t *testing.T) {
+	int64T, stringT := types.Typ[types.Int64], types.Typ[types.String]
+	user := newTable("User", []string{"Id", "Name"}, []types.Type{int64T, stringT}, []string{`json:"id"`, `json:"name"`})
+	group := newTable("Group", []string{"Id"}, []types.Type{int64T}, []string{`json:"id"`})
+	link := newTable("UserGroup",
+		[]string{"IdUser", "IdGroup"},
+		[]types.Type{int64T, int64T},
+		[]string{`json:"id_user"`, `json:"id_group"`},
+	)
+	if !link.IsLink() || user.IsLink() {
+		t.Fatal("invalid link detection")
+	}
+	tables := map[string]structSQL{"users": user, "groups": group, "user_groups": link}
+	loaders := link.linkLoaders(tables, map[string]bool{})
+	if len(loaders) != 2 || loaders[0].FuncName != "LoadGroupsForUsers" || loaders[1].FuncName != "LoadUsersForGroups" {
+		t.Fatal(loaders)
+	}
+	// a second link between the same tables
+	loaders = link.linkLoaders(tables, map[string]bool{"LoadGroupsForUsers": true})
+	if loaders[0].FuncName != "LoadGroupsForUsersViaUserGroupByIdUser" {
+		t.Fatal(loaders[0].FuncName)
+	}
+}
+
+func TestGraphLoader(t *testing.T) {
+	int64T := types.Typ[types.Int64]
+	user := newTable("User", []string{"Id"}, []types.Type{int64T}, []string{`json:"id"`})
+	order := newTable("Order", []string{"Id", "IdUser"}, []types.Type{int64T, int64T}, []string{`json:"id"`, `json:"id_user"`})
+	invoice := newTable("Invoice", []string{"Id", "IdOrder"}, []types.Type{int64T, int64T}, []string{`json:"id"`, `json:"id_order"`})
+
+	g := newGraphLoader(user, []structSQL{invoice, order, user})
+	if len(g.Relations) != 2 {
+		t.Fatal(g.Relations)
+	}
+	orders, invoices := g.Relations[0], g.Relations[1]
+	if orders.Field() != "Orders" || orders.Parent != -1 || g.ParentField(orders) != "Users" {
+		t.Fatal(orders)
+	}
+	if invoices.Field() != "Invoices" || g.ParentConstant(invoices) != "UserWithOrders" || g.ParentField(invoices) != "Orders" {
+		t.Fatal(invoices)
+	}
+
+	var code bytes.Buffer
+	if err := templateGraphLoader.Execute(&code, g); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(code.String(), `return out, fmt.Errorf("invalid UserRelation %d", rel)`) {
+		t.Fatal(code.String())
+	}
+}
+
+func TestDeletePlanner(t *testing.T) {
+	int64T := types.Typ[types.Int64]
+	user := newTable("User", []string{"Id"}, []types.Type{int64T}, []string{`json:"id"`})
+	order := newTable("Order", []string{"Id", "IdUser"}, []types.Type{int64T, int64T}, []string{`json:"id"`, `json:"id_user" sql_on_delete:"cascade"`})
+	invoice := newTable("Invoice", []string{"Id", "IdOrder"}, []types.Type{int64T, int64T}, []string{`json:"id"`, `json:"id_order" sql_on_delete:"SET NULL"`})
+
+	l := handler{tables: []structSQL{user, order, invoice}}
+	steps := l.newDeletePlanner(user).Steps
+	if len(steps) != 2 {
+		t.Fatal(steps)
+	}
+	if s := steps[0]; s.Table != "orders" || s.Action != "CASCADE" || !s.HasDependents() || s.From != "pq.Int64Array(ids)" {
+		t.Fatal(s)
+	}
+	if s := steps[1]; s.Table != "invoices" || s.Action != "SET NULL" || s.HasDependents() || s.From != "pq.Int64Array(ids1)" {
+		t.Fatal(s)
+	}
+	if steps := l.newDeletePlanner(invoice).Steps; len(steps) != 0 {
+		t.Fatal(steps)
+	}
+}
+
+func TestOutbox(t *testing.T) {
+	int64T := types.Typ[types.Int64]
+	link := newTable("UserGroup", []string{"IdUser", "IdGroup"}, []types.Type{int64T, int64T}, []string{`json:"id_user"`, `json:"id_group"`})
+	got := link.WriteOutbox("INSERT", "item", "nil", "item")
+	expected := `err = writeOutbox(tx, "user_groups", "INSERT", map[string]interface{}{"id_user": item.IdUser, "id_group": item.IdGroup}, nil, item)`
+	if got != expected {
+		t.Fatal(got)
+	}
+
+	// every function changing rows must write the outbox
+	post := newTable("Post",
+		[]string{"Id", "IdUser", "Title", "DeletedAt"},
+		[]types.Type{int64T, int64T, types.Typ[types.String], types.NewPointer(types.Typ[types.String])},
+		[]string{`json:"id"`, `json:"id_user"`, `json:"title"`, `sql:",soft_delete"`},
+	)
+	post.options.Outbox, link.options.Outbox = true, true
+	var code bytes.Buffer
+	for _, table := range []structSQL{post, link} {
+		tmpl := templateStructWithID
+		if !table.HasID() {
+			tmpl = templateStructLink
+		}
+		for _, step := range []struct {
+			tmpl *template.Template
+			data interface{}
+		}{
+			{tmpl, table},
+			{templateStructWithKey, table},
+			{templateSelectBy, table},
+			{templateUpsert, table.upserts([][]string{{"title"}})},
+		} {
+			if err := step.tmpl.Execute(&code, step.data); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	for _, fn := range strings.Split(code.String(), "\nfunc ")[1:] {
+		isMutating := strings.Contains(fn, "INSERT INTO") || strings.Contains(fn, "UPDATE ") || strings.Contains(fn, "DELETE FROM")
+		if isMutating && !strings.Contains(fn, "writeOutbox(") {
+			t.Fatalf("missing outbox record in %s", fn)
+		}
+		// deletes, soft or not, record the row before the deletion
+		if strings.Contains(fn, `"DELETE", `) && !strings.Contains(fn, ", item, nil)") {
+			t.Fatalf("invalid DELETE record in %s", fn)
+		}
+	}
+}
@@ -0,0 +1,81 @@
+package crud
+
+import (
+	"fmt"
+	"strings"
+	"text/template"
+
+	"github.com/benoitkugler/structgen/orm"
+)
+
+// code included with the Outbox option.
+// The template is executed with the WithContext option.
+var templateOutbox = template.Must(template.New("").Parse(`
+// writeOutbox records the change of the row of 'table' identified by 'key'
+// in the ` + orm.OutboxTable + ` table. 'operation' is one of INSERT, UPDATE, DELETE,
+// UPSERT or RESTORE (for soft deleted rows). 'before' is nil for inserts, upserts
+// and restores, and 'after' is nil for deletes, where 'before' is the deleted row
+// (for soft deletes, as of the deletion).
+func writeOutbox({{ if . }}ctx context.Context, {{ end }}tx DB, table, operation string, key map[string]interface{}, before, after interface{}) error {
+	keyJSON, err := dumpJSON(key)
+	if err != nil {
+		return err
+	}
+	var beforeJSON, afterJSON driver.Value
+	if before != nil {
+		if beforeJSON, err = dumpJSON(before); err != nil {
+			return err
+		}
+	}
+	if after != nil {
+		if afterJSON, err = dumpJSON(after); err != nil {
+			return err
+		}
+	}
+	const query = "INSERT INTO ` + orm.OutboxTable + ` (table_name, operation, key, before, after) VALUES ($1, $2, $3, $4, $5)"
+	{{- if . }}
+	_, err = execContext(ctx, tx, "writeOutbox", query, table, operation, keyJSON, beforeJSON, afterJSON)
+	{{- else }}
+	_, err = tx.Exec(query, table, operation, keyJSON, beforeJSON, afterJSON)
+	{{- end }}
+	return err
+}
+`))
+
+// HasOutbox returns `true` if the changes are recorded in the outbox table.
+func (m structSQL) HasOutbox() bool { return m.options.Outbox }
+
+// outboxKey returns the columns identifying a row in the change records :
+// the primary key, or the link keys, or all the columns.
+func (m structSQL) outboxKey() []orm.SQLField {
+	if pk := m.Fields.Primary(); len(pk) != 0 {
+		return pk
+	}
+	if keys := m.LinkKeys(); len(keys) != 0 {
+		return keys
+	}
+	return m.Fields.Exported()
+}
+
+// WriteOutbox returns the statement recording the change of the row `item`
+// (a Go variable), with the given `before` and `after` values.
+func (m structSQL) WriteOutbox(operation, item, before, after string) string {
+	var key []string
+	for _, field := range m.outboxKey() {
+		key = append(key, fmt.Sprintf("%q: %s.%s", field.SQLName, item, field.GoName))
+	}
+	return fmt.Sprintf("err = writeOutbox(%stx, %q, %q, map[string]interface{}{%s}, %s, %s)",
+		m.CtxArg(), m.TableName(), operation, strings.Join(key, ", "), before, after)
+}
+
+// WriteOutboxItems returns the loop recording the change of each row of `items`
+// (a Go variable), with the given `before` and `after` values, which may refer to `item`.
+// The loop stops at the first error.
+func (m structSQL) WriteOutboxItems(operation, items, before, after string) string {
+	return fmt.Sprintf(`for _, item := range %s {
+		%s
+		if err != nil {
+			break
+		}
+	}`, items, m.WriteOutbox(operation, "item", before, after))
+}
@@ -0,0 +1,330 @@
+package crud
+
+import (
+	"bytes"
+	"go/types"
+	"strings"
+	"text/template"
+
+	"github.com/benoitkugler/structgen/loader"
+	"github.com/benoitkugler/structgen/orm"
+	"github.com/benoitkugler/structgen/orm/sqltypes"
+)
+
+// code included once, supporting the generated query builders.
+// The template is executed with the WithContext option.
+var templateQueryUtils = template.Must(template.New("").Parse(`
+// Condition is a SQL boolean expression, whose arguments are
+// numbered when the query is built.
+type Condition struct {
+	render func(args *[]interface{}) string
+}
+
+func compare(column, operator string, value interface{}) Condition {
+	return Condition{func(args *[]interface{}) string {
+		*args = append(*args, value)
+		return fmt.Sprintf("%s %s $%d", column, operator, len(*args))
+	}}
+}
+
+func anyOf(column string, values interface{}) Condition {
+	return Condition{func(args *[]interface{}) string {
+		*args = append(*args, values)
+		return fmt.Sprintf("%s = ANY($%d)", column, len(*args))
+	}}
+}
+
+func rawCondition(sql string) Condition {
+	return Condition{func(*[]interface{}) string { return sql }}
+}
+
+// combine joins the conditions with 'operator', or returns 'empty'
+// if there is no condition.
+func combine(operator, empty string, conds []Condition) Condition {
+	return Condition{func(args *[]interface{}) string {
+		if len(conds) == 0 {
+			return empty
+		}
+		chunks := make([]string, len(conds))
+		for i, cond := range conds {
+			chunks[i] = "(" + cond.render(args) + ")"
+		}
+		return strings.Join(chunks, " "+operator+" ")
+	}}
+}
+
+// And returns a condition matching all of 'conds',
+// which is TRUE if 'conds' is empty.
+func And(conds ...Condition) Condition { return combine("AND", "TRUE", conds) }
+
+// Or returns a condition matching one of 'conds',
+// which is FALSE if 'conds' is empty.
+func Or(conds ...Condition) Condition { return combine("OR", "FALSE", conds) }
+
+// Not negates the condition.
+func Not(cond Condition) Condition {
+	return Condition{func(args *[]interface{}) string { return "NOT (" + cond.render(args) + ")" }}
+}
+
+// Ordering is an item of an ORDER BY clause.
+type Ordering string
+
+// query is a SELECT statement on one table,
+// shared by the generated query builders.
+type query struct {
+	table         string
+	conditions    []Condition
+	orderings     []Ordering
+	limit, offset int
+}
+
+func (q query) where(conds []Condition) query {
+	q.conditions = append(q.conditions[:len(q.conditions):len(q.conditions)], conds...)
+	return q
+}
+
+func (q query) orderBy(orderings []Ordering) query {
+	q.orderings = append(q.orderings[:len(q.orderings):len(q.orderings)], orderings...)
+	return q
+}
+
+func (q query) whereClause(args *[]interface{}) string {
+	if len(q.conditions) == 0 {
+		return ""
+	}
+	return " WHERE " + And(q.conditions...).render(args)
+}
+
+// SQL returns the parametrized SQL query and its arguments.
+func (q query) SQL() (string, []interface{}) {
+	var args []interface{}
+	stmt := "SELECT * FROM " + q.table + q.whereClause(&args)
+	if len(q.orderings) != 0 {
+		chunks := make([]string, len(q.orderings))
+		for i, o := range q.orderings {
+			chunks[i] = string(o)
+		}
+		stmt += " ORDER BY " + strings.Join(chunks, ", ")
+	}
+	if q.limit > 0 {
+		stmt += fmt.Sprintf(" LIMIT %d", q.limit)
+	}
+	if q.offset > 0 {
+		stmt += fmt.Sprintf(" OFFSET %d", q.offset)
+	}
+	return stmt, args
+}
+
+{{ if . -}}
+func (q query) count(ctx context.Context, tx DB, name string) (int, error) {
+	var args []interface{}
+	stmt := "SELECT count(*) FROM " + q.table + q.whereClause(&args)
+	var count int
+	err := queryRowContext(ctx, tx, name, stmt, args...).Scan(&count)
+	return count, err
+}
+
+func (q query) run(ctx context.Context, tx DB, name string) (*sql.Rows, error) {
+	stmt, args := q.SQL()
+	return queryContext(ctx, tx, name, stmt, args...)
+}
+{{- else -}}
+func (q query) count(tx DB) (int, error) {
+	var args []interface{}
+	stmt := "SELECT count(*) FROM " + q.table + q.whereClause(&args)
+	var count int
+	err := tx.QueryRow(stmt, args...).Scan(&count)
+	return count, err
+}
+
+func (q query) run(tx DB) (*sql.Rows, error) {
+	stmt, args := q.SQL()
+	return tx.Query(stmt, args...)
+}
+{{- end }}
+`))
+
+// columnType is the Go type of a column, used to generate
+// the typed filters
+type columnType struct {
+	GoType string
+	Name   string // name of the generated type
+	IsText bool   // add the Like filter
+	Codec  sqltypes.Codec
+}
+
+// Value returns the expression sending `expr` as query argument.
+func (c columnType) Value(expr string) string { return c.Codec.Value(expr) }
+
+// newColumnType returns the column type for `field`. Fields which are
+// neither basic types nor structs (slices, maps, pointers) are compared as interface{}.
+func newColumnType(field orm.SQLField) columnType {
+	return columnTypeOf(field.Type.Go, field.GoTypeName, field.Type.Codec)
+}
+
+// columnTypeOf returns the column type storing values of type `typ`,
+// written `goType` in the generated package.
+func columnTypeOf(typ types.Type, goType string, codec sqltypes.Codec) columnType {
+	var isText bool
+	switch under := typ.Underlying().(type) {
+	case *types.Basic:
+		isText = under.Info()&types.IsString != 0
+	case *types.Struct:
+	default:
+		if codec == sqltypes.NoCodec {
+			return columnType{GoType: "interface{}", Name: "ColumnAny"}
+		}
+	}
+	name := goType
+	if i := strings.LastIndexByte(name, '.'); i != -1 {
+		name = name[i+1:]
+	}
+	return columnType{
+		GoType: goType,
+		Name:   "Column" + strings.ToUpper(name[:1]) + name[1:],
+		IsText: isText,
+		Codec:  codec,
+	}
+}
+
+var (
+	templateColumnType = template.Must(template.New("").Parse(`
+// {{ .Name }} is a column storing {{ .GoType }} values.
+type {{ .Name }} string
+
+func (c {{ .Name }}) Eq(v {{ .GoType }}) Condition  { return compare(string(c), "=", {{ .Value "v" }}) }
+func (c {{ .Name }}) Neq(v {{ .GoType }}) Condition { return compare(string(c), "<>", {{ .Value "v" }}) }
+func (c {{ .Name }}) Lt(v {{ .GoType }}) Condition  { return compare(string(c), "<", {{ .Value "v" }}) }
+func (c {{ .Name }}) Lte(v {{ .GoType }}) Condition { return compare(string(c), "<=", {{ .Value "v" }}) }
+func (c {{ .Name }}) Gt(v {{ .GoType }}) Condition  { return compare(string(c), ">", {{ .Value "v" }}) }
+func (c {{ .Name }}) Gte(v {{ .GoType }}) Condition { return compare(string(c), ">=", {{ .Value "v" }}) }
+{{- if .IsText }}
+func (c {{ .Name }}) Like(pattern string) Condition { return compare(string(c)+"::text", "LIKE", pattern) }
+{{- end }}
+
+// In matches the rows whose column is one of 'vs'.
+{{ with .Codec.ValueType -}}
+func (c {{ $.Name }}) In(vs ...{{ $.GoType }}) Condition {
+	values := make([]{{ . }}, len(vs))
+	for i, v := range vs {
+		values[i] = {{ . }}(v)
+	}
+	return anyOf(string(c), pq.Array(values))
+}
+{{- else -}}
+func (c {{ .Name }}) In(vs ...{{ .GoType }}) Condition { return anyOf(string(c), pq.Array(vs)) }
+{{- end }}
+
+func (c {{ .Name }}) IsNull() Condition    { return rawCondition(string(c) + " IS NULL") }
+func (c {{ .Name }}) IsNotNull() Condition { return rawCondition(string(c) + " IS NOT NULL") }
+
+func (c {{ .Name }}) Asc() Ordering  { return Ordering(string(c) + " ASC") }
+func (c {{ .Name }}) Desc() Ordering { return Ordering(string(c) + " DESC") }
+`))
+
+	templateQuery = template.Must(template.New("").Funcs(fnMap).Parse(`
+// {{ .Name }}Col lists the columns of the table {{ snake .Name }}s,
+// to be used with Query{{ .Name }}s.
+var {{ .Name }}Col = struct {
+	{{ range .Fields }}{{ .GoName }} {{ (columnType .).Name }}
+	{{ end }}
+}{
+	{{ range .Fields }}{{ .GoName }}: "{{ .SQLName }}",
+	{{ end }}
+}
+
+// {{ .Name }}Query is a SELECT query on the table {{ snake .Name }}s.
+type {{ .Name }}Query struct {
+	query
+}
+
+// Query{{ .Name }}s starts a query on the table {{ snake .Name }}s
+{{- if .SoftDeleteColumn }}, excluding the deleted rows{{ end }}.
+func Query{{ .Name }}s() {{ .Name }}Query {
+	q := {{ .Name }}Query{query{table: "{{ snake .Name }}s"}}
+	{{- with .SoftDeleteColumn }}
+	q.conditions = []Condition{rawCondition("{{ . }} IS NULL")}
+	{{- end }}
+	return q
+}
+
+// Where adds conditions, which must all be satisfied.
+func (q {{ .Name }}Query) Where(conds ...Condition) {{ .Name }}Query {
+	return {{ .Name }}Query{q.where(conds)}
+}
+
+// OrderBy adds orderings, used by SelectOrdered and Iter.
+func (q {{ .Name }}Query) OrderBy(orderings ...Ordering) {{ .Name }}Query {
+	return {{ .Name }}Query{q.orderBy(orderings)}
+}
+
+// Limit restricts the number of rows returned.
+func (q {{ .Name }}Query) Limit(limit int) {{ .Name }}Query {
+	q.limit = limit
+	return q
+}
+
+// Offset skips the first rows.
+func (q {{ .Name }}Query) Offset(offset int) {{ .Name }}Query {
+	q.offset = offset
+	return q
+}
+
+// Select runs the query.
+func (q {{ .Name }}Query) Select({{ .CtxParam }}tx DB) ({{ .Name }}s, error) {
+	rows, err := q.run({{ .QueryArgs (print .Name "Query.Select") }})
+	if err != nil {
+		return nil, err
+	}
+	return Scan{{ .Name }}s(rows)
+}
+
+// SelectOrdered runs the query and returns the items
+// in the order defined by OrderBy.
+func (q {{ .Name }}Query) SelectOrdered({{ .CtxParam }}tx DB) ([]{{ .Name }}, error) {
+	var out []{{ .Name }}
+	err := q.Iter({{ .CtxArg }}tx, func(item {{ .Name }}) error {
+		out = append(out, item)
+		return nil
+	})
+	return out, err
+}
+
+// Iter runs the query and calls 'fn' for each row, scanned one at a time.
+// It stops at the first error returned by 'fn'.
+func (q {{ .Name }}Query) Iter({{ .CtxParam }}tx DB, fn func({{ .Name }}) error) error {
+	rows, err := q.run({{ .QueryArgs (print .Name "Query.Iter") }})
+	if err != nil {
+		return err
+	}
+	return iter{{ .Name }}s(rows, fn)
+}
+
+// Count returns the number of rows matching the query,
+// ignoring Limit and Offset.
+func (q {{ .Name }}Query) Count({{ .CtxParam }}tx DB) (int, error) {
+	return q.count({{ .QueryArgs (print .Name "Query.Count") }})
+}
+`))
+)
+
+// renderQuery returns the query builder of the table
+// and the column types it requires.
+func (m structSQL) renderQuery() []loader.Declaration {
+	var decls []loader.Declaration
+	for _, field := range m.Fields {
+		ct := newColumnType(field)
+		var code bytes.Buffer
+		if err := templateColumnType.Execute(&code, ct); err != nil {
+			panic(err)
+		}
+		decls = append(decls, loader.Declaration{Id: "column_type_" + ct.Name, Content: code.String()})
+	}
+	decls = append(decls, m.renderJSONPaths()...)
+
+	var code bytes.Buffer
+	if err := templateQuery.Execute(&code, m); err != nil {
+		panic(err)
+	}
+	return append(decls, loader.Declaration{Id: "query_" + m.Name, Content: code.String()})
+}
@@ -6,12 +6,21 @@ import (
 	"github.com/benoitkugler/structgen/orm"
 )
 
+// fnMap extends orm.FnMap with crud specific helpers
+var fnMap = func() template.FuncMap {
+	out := template.FuncMap{"keyType": newKeyType, "columnType": newColumnType}
+	for name, fn := range orm.FnMap {
+		out[name] = fn
+	}
+	return out
+}()
+
 var (
-	templateScan = template.Must(template.New("").Funcs(orm.FnMap).Parse(`
+	templateScan = template.Must(template.New("").Funcs(fnMap).Parse(`
 func scanOne{{ .Name }}(row scanner) ({{ .Name }}, error) {
 	var s {{.Name}}
 	err := row.Scan({{range .Fields}}
-		&s.{{.GoName}},{{end}}
+		{{ .ScanArg "s" }},{{end}}
 	)
 	return s, err
 }
@@ -20,36 +29,89 @@ func Scan{{ .Name }}(row *sql.Row) ({{.Name}}, error) {
 	return scanOne{{ .Name }}(row)
 }
 
-func SelectAll{{ .Name }}s(tx DB) ({{ .Name }}s, error) {
-	rows, err := tx.Query("SELECT * FROM {{snake .Name}}s")
+func SelectAll{{ .Name }}s({{ $.CtxParam }}tx DB) ({{ .Name }}s, error) {
+	rows, err := {{ $.Call "Query" (print "SelectAll" $.Name "s") }}"SELECT * FROM {{snake .Name}}s{{ .WhereNotDeleted }}")
 	if err != nil {
 		return nil, err
 	}
 	return Scan{{ .Name }}s(rows)
 }
+
+// Count{{ .Name }}s returns the number of rows in the table{{ if .SoftDeleteColumn }}, excluding the deleted ones{{ end }}.
+func Count{{ .Name }}s({{ $.CtxParam }}tx DB) (int, error) {
+	var count int
+	err := {{ $.Call "QueryRow" (print "Count" $.Name "s") }}"SELECT count(*) FROM {{snake .Name}}s{{ .WhereNotDeleted }}").Scan(&count)
+	return count, err
+}
+
+// Iter{{ .Name }}s calls 'fn' for each row of the table{{ if .SoftDeleteColumn }} (excluding the deleted ones){{ end }}, 
+// scanned one at a time. It stops at the first error returned by 'fn'.
+func Iter{{ .Name }}s({{ $.CtxParam }}tx DB, fn func({{ .Name }}) error) error {
+	rows, err := {{ $.Call "Query" (print "Iter" $.Name "s") }}"SELECT * FROM {{snake .Name}}s{{ .WhereNotDeleted }}")
+	if err != nil {
+		return err
+	}
+	return iter{{ .Name }}s(rows, fn)
+}
+
+func iter{{ .Name }}s(rows *sql.Rows, fn func({{ .Name }}) error) (err error) {
+	defer func() {
+		errClose := rows.Close()
+		if err == nil {
+			err = errClose
+		}
+	}()
+	for rows.Next() {
+		s, err := scanOne{{ .Name }}(rows)
+		if err != nil {
+			return err
+		}
+		if err = fn(s); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
 `))
 
-	templateStructWithID = template.Must(template.New("").Funcs(orm.FnMap).Parse(`
+	templateStructWithID = template.Must(template.New("").Funcs(fnMap).Parse(`
+{{- $key := keyType .IDField -}}
+{{- $id := .IDField.SQLName -}}
 
 // Select{{ .Name }} returns the entry matching id.
-func Select{{ .Name }}(tx DB, id int64) ({{ .Name }}, error) {
-	row := tx.QueryRow("SELECT * FROM {{snake .Name}}s WHERE id = $1", id)
+func Select{{ .Name }}({{ $.CtxParam }}tx DB, id {{ $key }}) ({{ .Name }}, error) {
+	row := {{ $.Call "QueryRow" (print "Select" $.Name) }}"SELECT * FROM {{snake .Name}}s WHERE {{ $id }} = $1{{ .AndNotDeleted }}", id)
 	return Scan{{ .Name }}(row)
 }
 
 // Select{{ .Name }}s returns the entry matching the given ids.
-func Select{{ .Name }}s(tx DB, ids ...int64) ({{ .Name }}s, error) {
-	rows, err := tx.Query("SELECT * FROM {{snake .Name}}s WHERE id = ANY($1)", pq.Int64Array(ids))
+func Select{{ .Name }}s({{ $.CtxParam }}tx DB, ids ...{{ $key }}) ({{ .Name }}s, error) {
+	rows, err := {{ $.Call "Query" (print "Select" $.Name "s") }}"SELECT * FROM {{snake .Name}}s WHERE {{ $id }} = ANY($1){{ .AndNotDeleted }}", {{ $key.AsSQL "ids" }})
 	if err != nil {
 		return nil, err
 	}
 	return Scan{{ .Name }}s(rows)
 }
 
-type {{.Name}}s map[int64]{{.Name}}
+// Select{{ .Name }}Page returns at most 'limit' entries whose id is greater
+// than 'afterID', sorted by id. The last one is the start of the next page.
+func Select{{ .Name }}Page({{ $.CtxParam }}tx DB, afterID {{ $key }}, limit int) ([]{{ .Name }}, error) {
+	rows, err := {{ $.Call "Query" (print "Select" $.Name "Page") }}"SELECT * FROM {{snake .Name}}s WHERE {{ $id }} > $1{{ .AndNotDeleted }} ORDER BY {{ $id }} LIMIT $2", afterID, limit)
+	if err != nil {
+		return nil, err
+	}
+	var out []{{ .Name }}
+	err = iter{{ .Name }}s(rows, func(item {{ .Name }}) error {
+		out = append(out, item)
+		return nil
+	})
+	return out, err
+}
+
+type {{.Name}}s map[{{ $key }}]{{.Name}}
 
-func (m {{.Name}}s) IDs() IDs {
-	out := make(IDs, 0, len(m))
+func (m {{.Name}}s) IDs() {{ $key.IDs }} {
+	out := make({{ $key.IDs }}, 0, len(m))
 	for i := range m {
 		out = append(out, i)
 	}
@@ -73,7 +135,7 @@ func Scan{{ .Name }}s(rs *sql.Rows) ({{.Name}}s, error) {
 		if err != nil {
 			return nil, err
 		}
-		structs[s.Id] = s
+		structs[s.{{ .IDField.GoName }}] = s
 	}
 	if err = rs.Err(); err != nil {
 		return nil, err
@@ -81,47 +143,209 @@ func Scan{{ .Name }}s(rs *sql.Rows) ({{.Name}}s, error) {
 	return structs, nil
 }
 
+{{- $insert := .InsertAssignment }}
 // Insert {{ .Name }} in the database and returns the item with id filled.
-func (item {{ .Name }}) Insert(tx DB) (out {{.Name}}, err error) {
-	row := tx.QueryRow(` + "`" + `INSERT INTO {{snake .Name}}s (
-		{{range $i, $e :=  .Fields.Exported.NoId }}{{if $i}},{{end}}{{ $e.SQLName }}{{end}}
+func (item {{ .Name }}) Insert({{ $.CtxParam }}tx DB) (out {{.Name}}, err error) {
+	row := {{ $.Call "QueryRow" (print $.Name ".Insert") }}` + "`" + `INSERT INTO {{snake .Name}}s (
+		{{ $insert.Columns }}
 		) VALUES (
-		{{range $i, $e :=  .Fields.Exported.NoId }}{{if $i}},{{end}}${{inc $i}}{{end}}
+		{{ $insert.Values }}
 		) RETURNING 
 		{{range $i, $e := .Fields}}{{if $i}},{{end}}{{ $e.SQLName }}{{end}};
-		` + "`" + `{{range  .Fields.Exported.NoId }},item.{{.GoName}}{{end}})
+		` + "`" + `{{ $insert.Args }})
+	{{- if .HasOutbox }}
+	out, err = Scan{{ .Name }}(row)
+	if err != nil {
+		return out, err
+	}
+	{{ .WriteOutbox "INSERT" "out" "nil" "out" }}
+	return out, err
+	{{- else }}
 	return Scan{{ .Name }}(row)
+	{{- end }}
 }
 
-// Update {{ .Name }} in the database and returns the new version.
-func (item {{ .Name }}) Update(tx DB) (out {{.Name}}, err error) {
-	row := tx.QueryRow(` + "`" + `UPDATE {{snake .Name}}s SET (
-		{{range $i, $e := .Fields.Exported.NoId }}{{if $i}},{{end}}{{ $e.SQLName }}{{end}}
-		) = (
-		{{range $i, $e := .Fields.Exported.NoId }}{{if $i}},{{end}}${{inc (inc $i)}}{{end}}
-		) WHERE id = $1 RETURNING 
-		{{range $i, $e := .Fields }}{{if $i}},{{end}}{{ $e.SQLName }}{{end}};
-		` + "`" + `{{range .Fields.Exported }},item.{{.GoName}}{{end}})
-	return Scan{{ .Name }}(row)
+{{ with .SoftDeleteColumn -}}
+// Deletes the {{ $.Name }} by setting its '{{ . }}' column, and returns the item
+func Delete{{ $.Name }}ById({{ $.CtxParam }}tx DB, id {{ $key }}) ({{ $.Name }}, error) {
+	row := {{ $.Call "QueryRow" (print "Delete" $.Name "ById") }}"UPDATE {{snake $.Name}}s SET {{ . }} = now() WHERE {{ $id }} = $1 AND {{ . }} IS NULL RETURNING *;", id)
+	{{- if $.HasOutbox }}
+	item, err := Scan{{ $.Name }}(row)
+	if err != nil {
+		return item, err
+	}
+	{{ $.WriteOutbox "DELETE" "item" "item" "nil" }}
+	return item, err
+	{{- else }}
+	return Scan{{ $.Name }}(row)
+	{{- end }}
+}
+
+// Deletes the {{ $.Name }} by setting their '{{ . }}' column, and returns the ids.
+func Delete{{ $.Name }}sByIDs({{ $.CtxParam }}tx DB, ids ...{{ $key }}) ({{ $key.IDs }}, error) {
+	rows, err := {{ $.Call "Query" (print "Delete" $.Name "sByIDs") }}"UPDATE {{ snake $.Name }}s SET {{ . }} = now() WHERE {{ $id }} = ANY($1) AND {{ . }} IS NULL RETURNING {{ if $.HasOutbox }}*{{ else }}{{ $id }}{{ end }}", {{ $key.AsSQL "ids" }})
+	if err != nil {
+		return nil, err
+	}
+	{{- if $.HasOutbox }}
+	items, err := Scan{{ $.Name }}s(rows)
+	if err != nil {
+		return nil, err
+	}
+	{{ $.WriteOutboxItems "DELETE" "items" "item" "nil" }}
+	return items.IDs(), err
+	{{- else }}
+	return {{ $key.ScanIDs }}(rows)
+	{{- end }}
+}
+
+// Restore{{ $.Name }}ById cancels the deletion of the {{ $.Name }} and returns the item
+func Restore{{ $.Name }}ById({{ $.CtxParam }}tx DB, id {{ $key }}) ({{ $.Name }}, error) {
+	row := {{ $.Call "QueryRow" (print "Restore" $.Name "ById") }}"UPDATE {{snake $.Name}}s SET {{ . }} = NULL WHERE {{ $id }} = $1 RETURNING *;", id)
+	{{- if $.HasOutbox }}
+	item, err := Scan{{ $.Name }}(row)
+	if err != nil {
+		return item, err
+	}
+	{{ $.WriteOutbox "RESTORE" "item" "nil" "item" }}
+	return item, err
+	{{- else }}
+	return Scan{{ $.Name }}(row)
+	{{- end }}
 }
 
+// Restore{{ $.Name }}sByIDs cancels the deletion of the {{ $.Name }} and returns the ids.
+func Restore{{ $.Name }}sByIDs({{ $.CtxParam }}tx DB, ids ...{{ $key }}) ({{ $key.IDs }}, error) {
+	rows, err := {{ $.Call "Query" (print "Restore" $.Name "sByIDs") }}"UPDATE {{ snake $.Name }}s SET {{ . }} = NULL WHERE {{ $id }} = ANY($1) AND {{ . }} IS NOT NULL RETURNING {{ if $.HasOutbox }}*{{ else }}{{ $id }}{{ end }}", {{ $key.AsSQL "ids" }})
+	if err != nil {
+		return nil, err
+	}
+	{{- if $.HasOutbox }}
+	items, err := Scan{{ $.Name }}s(rows)
+	if err != nil {
+		return nil, err
+	}
+	{{ $.WriteOutboxItems "RESTORE" "items" "nil" "item" }}
+	return items.IDs(), err
+	{{- else }}
+	return {{ $key.ScanIDs }}(rows)
+	{{- end }}
+}
+{{- else -}}
 // Deletes the {{ .Name }} and returns the item
-func Delete{{ .Name }}ById(tx DB, id int64) ({{ .Name }}, error) {
-	row := tx.QueryRow("DELETE FROM {{snake .Name}}s WHERE id = $1 RETURNING *;", id)
+func Delete{{ .Name }}ById({{ $.CtxParam }}tx DB, id {{ $key }}) ({{ .Name }}, error) {
+	row := {{ $.Call "QueryRow" (print "Delete" $.Name "ById") }}"DELETE FROM {{snake .Name}}s WHERE {{ $id }} = $1 RETURNING *;", id)
+	{{- if .HasOutbox }}
+	item, err := Scan{{ .Name }}(row)
+	if err != nil {
+		return item, err
+	}
+	{{ .WriteOutbox "DELETE" "item" "item" "nil" }}
+	return item, err
+	{{- else }}
 	return Scan{{ .Name }}(row)
+	{{- end }}
 }
 
 // Deletes the {{ .Name }} in the database and returns the ids.
-func Delete{{ .Name }}sByIDs(tx DB, ids ...int64) (IDs, error) {
-	rows, err := tx.Query("DELETE FROM {{ snake .Name }}s WHERE id = ANY($1) RETURNING id", pq.Int64Array(ids))
+func Delete{{ .Name }}sByIDs({{ $.CtxParam }}tx DB, ids ...{{ $key }}) ({{ $key.IDs }}, error) {
+	rows, err := {{ $.Call "Query" (print "Delete" $.Name "sByIDs") }}"DELETE FROM {{ snake .Name }}s WHERE {{ $id }} = ANY($1) RETURNING {{ if .HasOutbox }}*{{ else }}{{ $id }}{{ end }}", {{ $key.AsSQL "ids" }})
 	if err != nil {
 		return nil, err
 	}
-	return ScanIDs(rows)
+	{{- if .HasOutbox }}
+	items, err := Scan{{ .Name }}s(rows)
+	if err != nil {
+		return nil, err
+	}
+	{{ .WriteOutboxItems "DELETE" "items" "item" "nil" }}
+	return items.IDs(), err
+	{{- else }}
+	return {{ $key.ScanIDs }}(rows)
+	{{- end }}
 }	
+{{- end }}
+`))
+
+	// templateUpdate is used for tables with a primary key,
+	// and at least one column outside of the key.
+	templateUpdate = template.Must(template.New("").Funcs(fnMap).Parse(`
+{{- $update := .UpdateAssignment -}}
+// Update {{ .Name }} in the database and returns the new version.
+func (item {{ .Name }}) Update({{ $.CtxParam }}tx DB) (out {{.Name}}, err error) {
+	{{- if .HasOutbox }}
+	before, err := Scan{{ .Name }}({{ $.Call "QueryRow" (print $.Name ".Update") }}"SELECT * FROM {{ snake .Name }}s WHERE {{ range $i, $e := .Fields.Primary }}{{if $i}} AND {{end}}{{ $e.SQLName }} = ${{ inc $i }}{{ end }} FOR UPDATE"
+		{{- range .Fields.Primary }}, item.{{ .GoName }}{{ end }}))
+	if err != nil {
+		return out, err
+	}
+	{{- end }}
+	row := {{ $.Call "QueryRow" (print $.Name ".Update") }}` + "`" + `UPDATE {{snake .Name}}s SET (
+		{{ $update.Columns }}
+		) = (
+		{{ $update.Values }}
+		) WHERE {{range $i, $e := .Fields.Primary }}{{if $i}} AND {{end}}{{ $e.SQLName }} = ${{inc $i}}{{end}}{{ $update.VersionCheck }} RETURNING 
+		{{range $i, $e := .Fields }}{{if $i}},{{end}}{{ $e.SQLName }}{{end}};
+		` + "`" + `{{range .Fields.Primary }},item.{{.GoName}}{{end}}{{ $update.Args }}{{ $update.VersionArg }})
+	{{- if or $update.VersionCheck .HasOutbox }}
+	out, err = Scan{{ .Name }}(row)
+	{{- if $update.VersionCheck }}
+	if err == sql.ErrNoRows {
+		return out, ErrConcurrentUpdate{Table: "{{ snake .Name }}s", Version: int64(item.{{ $update.VersionGoName }})}
+	}
+	{{- end }}
+	{{- if .HasOutbox }}
+	if err != nil {
+		return out, err
+	}
+	{{ .WriteOutbox "UPDATE" "out" "before" "out" }}
+	{{- end }}
+	return out, err
+	{{- else }}
+	return Scan{{ .Name }}(row)
+	{{- end }}
+}
+`))
+
+	// templateStructWithKey is used in addition to templateStructLink
+	// for tables with a composite primary key.
+	templateStructWithKey = template.Must(template.New("").Funcs(fnMap).Parse(`
+// {{ .Name }}Key is the primary key of {{ .Name }}.
+type {{ .Name }}Key struct {
+	{{ range .Fields.Primary }}{{ .GoName }} {{ .GoTypeName }}
+	{{ end }}
+}
+
+// Key returns the primary key of the item.
+func (item {{ .Name }}) Key() {{ .Name }}Key {
+	return {{ .Name }}Key{ {{ range .Fields.Primary }}{{ .GoName }}: item.{{ .GoName }}, {{ end }} }
+}
+
+// Select{{ .Name }}ByKey returns the entry matching key.
+func Select{{ .Name }}ByKey({{ $.CtxParam }}tx DB, key {{ .Name }}Key) ({{ .Name }}, error) {
+	row := {{ $.Call "QueryRow" (print "Select" $.Name "ByKey") }}"SELECT * FROM {{ snake .Name }}s WHERE {{ range $i, $e := .Fields.Primary }}{{if $i}} AND {{end}}{{ $e.SQLName }} = ${{ inc $i }}{{ end }}"
+		{{- range .Fields.Primary }}, key.{{ .GoName }}{{ end }})
+	return Scan{{ .Name }}(row)
+}
+
+// Delete{{ .Name }}ByKey deletes the entry matching key and returns it.
+func Delete{{ .Name }}ByKey({{ $.CtxParam }}tx DB, key {{ .Name }}Key) ({{ .Name }}, error) {
+	row := {{ $.Call "QueryRow" (print "Delete" $.Name "ByKey") }}"DELETE FROM {{ snake .Name }}s WHERE {{ range $i, $e := .Fields.Primary }}{{if $i}} AND {{end}}{{ $e.SQLName }} = ${{ inc $i }}{{ end }} RETURNING *"
+		{{- range .Fields.Primary }}, key.{{ .GoName }}{{ end }})
+	{{- if .HasOutbox }}
+	item, err := Scan{{ .Name }}(row)
+	if err != nil {
+		return item, err
+	}
+	{{ .WriteOutbox "DELETE" "item" "item" "nil" }}
+	return item, err
+	{{- else }}
+	return Scan{{ .Name }}(row)
+	{{- end }}
+}
 `))
 
-	templateStructLink = template.Must(template.New("").Funcs(orm.FnMap).Parse(`
+	templateStructLink = template.Must(template.New("").Funcs(fnMap).Parse(`
 type {{.Name}}s []{{.Name}}
 
 func Scan{{ .Name}}s(rs *sql.Rows) ({{.Name}}s , error) {
@@ -150,68 +374,88 @@ func Scan{{ .Name}}s(rs *sql.Rows) ({{.Name}}s , error) {
 }
 
 // Insert the links {{ .Name}} in the database.
-func InsertMany{{ .Name}}s(tx *sql.Tx, items ...{{ .Name}}) error {
+func InsertMany{{ .Name}}s({{ $.CtxParam }}tx *sql.Tx, items ...{{ .Name}}) error {
 	if len(items) == 0 {
 		return nil
 	}
 
-	stmt, err := tx.Prepare(pq.CopyIn("{{snake .Name}}s", 
-		{{range .Fields.Exported }}"{{ .SQLName }}",{{end}}
+	stmt, err := {{ $.Call "Prepare" (print "InsertMany" $.Name "s") }}pq.CopyIn("{{snake .Name}}s", 
+		{{range .CopyFields }}"{{ .SQLName }}",{{end}}
 	))
 	if err != nil {
 		return err
 	}
 
 	for _, item := range items {
-		_, err = stmt.Exec({{range $i, $e := .Fields.Exported }}{{if $i}},{{end}}item.{{.GoName}}{{end}})
+		_, err = {{ $.StmtExec }}{{range $i, $e := .CopyFields }}{{if $i}},{{end}}{{ .ValueArg "item" }}{{end}})
 		if err != nil {
 			return err
 		}
 	}
 
-	if _, err = stmt.Exec(); err != nil {
+	if _, err = {{ $.StmtExec }}); err != nil {
 		return err
 	}
 	
 	if err = stmt.Close(); err != nil {
 		return err
 	}
+	{{- if .HasOutbox }}
+
+	for _, item := range items {
+		{{ .WriteOutbox "INSERT" "item" "nil" "item" }}
+		if err != nil {
+			return err
+		}
+	}
+	{{- end }}
 	return nil
 }
 
 // Delete the link {{ .Name }} in the database.
-// Only the {{range .Fields.ForeignKeys }}'{{ .GoName }}' {{end}}fields are used.
-func (item {{ .Name }}) Delete(tx DB) error {
-	_, err := tx.Exec(` + "`" + `DELETE FROM {{snake .Name}}s WHERE 
-	{{range $i, $e := .Fields.ForeignKeys }}{{if $i}} AND {{end}}
+// Only the {{range .LinkKeys }}'{{ .GoName }}' {{end}}fields are used.
+func (item {{ .Name }}) Delete({{ $.CtxParam }}tx DB) error {
+	{{ if .HasOutbox }}rows, err := {{ $.Call "Query" (print $.Name ".Delete") }}{{ else }}_, err := {{ $.Call "Exec" (print $.Name ".Delete") }}{{ end }}` + "`" + `DELETE FROM {{snake .Name}}s WHERE 
+	{{range $i, $e := .LinkKeys }}{{if $i}} AND {{end}}
 	{{- if $e.Type.IsNullable -}}
 		( {{ $e.SQLName }} IS NULL OR {{ $e.SQLName }} = ${{inc $i}})
 	{{- else -}}
 		{{ $e.SQLName }} = ${{inc $i}}
 	{{- end -}}	
-	{{end}};` +
-		"`" + ` {{range .Fields.ForeignKeys }},item.{{.GoName}}{{end}})
+	{{end}}{{ if .HasOutbox }} RETURNING *{{ end }};` +
+		"`" + ` {{range .LinkKeys }},item.{{.GoName}}{{end}})
+	{{- if .HasOutbox }}
+	if err != nil {
+		return err
+	}
+	items, err := Scan{{ .Name }}s(rows)
+	if err != nil {
+		return err
+	}
+	{{ .WriteOutboxItems "DELETE" "items" "item" "nil" }}
+	{{- end }}
 	return err
 }
 
 `))
 
-	templateStructLinkToLookup = template.Must(template.New("").Funcs(orm.FnMap).Parse(`
+	templateStructLinkToLookup = template.Must(template.New("").Funcs(fnMap).Parse(`
 {{range .Fields.ForeignKeys }}
+	{{- $key := keyType . }}
 	{{ if .Type.IsNullable }}
 	{{ else }}
 		// By{{ .GoName }} returns a map with '{{ .GoName }}' as keys.
 		{{- if $.IsColumnUnique .SQLName }}
-		func (items {{$.Name}}s) By{{ .GoName }}() map[int64]{{ $.Name }} {
-			out := make(map[int64]{{ $.Name }}, len(items))
+		func (items {{$.Name}}s) By{{ .GoName }}() map[{{ $key }}]{{ $.Name }} {
+			out := make(map[{{ $key }}]{{ $.Name }}, len(items))
 			for _, target := range items {
 				out[target.{{ .GoName }}] = target
 			}
 			return out
 		}	
 		{{ else }}
-		func (items {{$.Name}}s) By{{ .GoName }}() map[int64]{{ $.Name }}s {
-			out := make(map[int64]{{ $.Name }}s)
+		func (items {{$.Name}}s) By{{ .GoName }}() map[{{ $key }}]{{ $.Name }}s {
+			out := make(map[{{ $key }}]{{ $.Name }}s)
 			for _, target := range items {
 				out[target.{{ .GoName }}] = append(out[target.{{ .GoName }}], target)
 			}
@@ -222,8 +466,8 @@ func (item {{ .Name }}) Delete(tx DB) error {
 		// {{ .GoName }}s returns the list of ids of {{ .GoName }}
 		// contained in this link table.
 		// They are not garanteed to be distinct.
-		func (items {{$.Name}}s) {{ .GoName }}s() IDs {
-			out := make(IDs, len(items))
+		func (items {{$.Name}}s) {{ .GoName }}s() {{ $key.IDs }} {
+			out := make({{ $key.IDs }}, len(items))
 			for index, target := range items {
 				out[index] = target.{{ .GoName }}
 			}
@@ -233,12 +477,14 @@ func (item {{ .Name }}) Delete(tx DB) error {
 	{{ end }}
 {{end}}`))
 
-	templateSelectBy = template.Must(template.New("").Funcs(orm.FnMap).Parse(`
+	templateSelectBy = template.Must(template.New("").Funcs(fnMap).Parse(`
 {{range .Fields.ForeignKeys }}
+{{- $key := keyType . }}
+{{- $field := . }}
 {{- if $.IsColumnUnique .SQLName }}
 // Select{{ $.Name }}By{{ .GoName }} return zero or one item, thanks to a UNIQUE constraint
-func Select{{ $.Name }}By{{ .GoName }}(tx DB, {{ varname .GoName }} int64) (item {{ $.Name }}, found bool, err error) {
-	row := tx.QueryRow("SELECT * FROM {{ snake $.Name }}s WHERE {{ .SQLName }} = $1", {{ varname .GoName}})
+func Select{{ $.Name }}By{{ .GoName }}({{ $.CtxParam }}tx DB, {{ varname .GoName }} {{ $key }}) (item {{ $.Name }}, found bool, err error) {
+	row := {{ $.Call "QueryRow" (print "Select" $.Name "By" $field.GoName) }}"SELECT * FROM {{ snake $.Name }}s WHERE {{ .SQLName }} = $1{{ $.AndNotDeleted }}", {{ varname .GoName}})
 	item, err = Scan{{ $.Name }}(row)
 	if err == sql.ErrNoRows {
 		return item, false, nil
@@ -247,8 +493,8 @@ func Select{{ $.Name }}By{{ .GoName }}(tx DB, {{ varname .GoName }} int64) (item
 }	
 {{ end }}
 
-func Select{{ $.Name }}sBy{{ .GoName }}s(tx DB, {{ varname .GoName }}s ...int64) ({{ $.Name }}s, error) {
-	rows, err := tx.Query("SELECT * FROM {{ snake $.Name }}s WHERE {{ .SQLName }} = ANY($1)", pq.Int64Array({{ varname .GoName}}s))
+func Select{{ $.Name }}sBy{{ .GoName }}s({{ $.CtxParam }}tx DB, {{ varname .GoName }}s ...{{ $key }}) ({{ $.Name }}s, error) {
+	rows, err := {{ $.Call "Query" (print "Select" $.Name "sBy" $field.GoName "s") }}"SELECT * FROM {{ snake $.Name }}s WHERE {{ .SQLName }} = ANY($1){{ $.AndNotDeleted }}", {{ $key.AsSQL (print (varname .GoName) "s") }})
 	if err != nil {
 		return nil, err
 	}
@@ -256,29 +502,56 @@ func Select{{ $.Name }}sBy{{ .GoName }}s(tx DB, {{ varname .GoName }}s ...int64)
 }	
 
 {{ if $.HasID }}
-func Delete{{ $.Name }}sBy{{ .GoName }}s(tx DB, {{ varname .GoName }}s ...int64) (IDs, error) {
-	rows, err := tx.Query("DELETE FROM {{ snake $.Name }}s WHERE {{ .SQLName }} = ANY($1) RETURNING id", pq.Int64Array({{ varname .GoName}}s))
+{{- $id := keyType $.IDField }}
+func Delete{{ $.Name }}sBy{{ .GoName }}s({{ $.CtxParam }}tx DB, {{ varname .GoName }}s ...{{ $key }}) ({{ $id.IDs }}, error) {
+	{{- $returning := $.IDField.SQLName }}{{ if $.HasOutbox }}{{ $returning = "*" }}{{ end }}
+	{{- with $.SoftDeleteColumn }}
+	rows, err := {{ $.Call "Query" (print "Delete" $.Name "sBy" $field.GoName "s") }}"UPDATE {{ snake $.Name }}s SET {{ . }} = now() WHERE {{ $field.SQLName }} = ANY($1) AND {{ . }} IS NULL RETURNING {{ $returning }}", {{ $key.AsSQL (print (varname $field.GoName) "s") }})
+	{{- else }}
+	rows, err := {{ $.Call "Query" (print "Delete" $.Name "sBy" $field.GoName "s") }}"DELETE FROM {{ snake $.Name }}s WHERE {{ .SQLName }} = ANY($1) RETURNING {{ $returning }}", {{ $key.AsSQL (print (varname .GoName) "s") }})
+	{{- end }}
+	if err != nil {
+		return nil, err
+	}
+	{{- if $.HasOutbox }}
+	items, err := Scan{{ $.Name }}s(rows)
 	if err != nil {
 		return nil, err
 	}
-	return ScanIDs(rows)
+	{{ $.WriteOutboxItems "DELETE" "items" "item" "nil" }}
+	return items.IDs(), err
+	{{- else }}
+	return {{ $id.ScanIDs }}(rows)
+	{{- end }}
 }	
 {{ else }}
-func Delete{{ $.Name }}sBy{{ .GoName }}s(tx DB, {{ varname .GoName }}s ...int64) ({{ $.Name }}s, error)  {
-	rows, err := tx.Query("DELETE FROM {{ snake $.Name }}s WHERE {{ .SQLName }} = ANY($1) RETURNING *", pq.Int64Array({{ varname .GoName}}s))
+func Delete{{ $.Name }}sBy{{ .GoName }}s({{ $.CtxParam }}tx DB, {{ varname .GoName }}s ...{{ $key }}) ({{ $.Name }}s, error)  {
+	rows, err := {{ $.Call "Query" (print "Delete" $.Name "sBy" $field.GoName "s") }}"DELETE FROM {{ snake $.Name }}s WHERE {{ .SQLName }} = ANY($1) RETURNING *", {{ $key.AsSQL (print (varname .GoName) "s") }})
 	if err != nil {
 		return nil, err
 	}
+	{{- if $.HasOutbox }}
+	items, err := Scan{{ $.Name }}s(rows)
+	if err != nil {
+		return nil, err
+	}
+	{{ $.WriteOutboxItems "DELETE" "items" "item" "nil" }}
+	return items, err
+	{{- else }}
 	return Scan{{ $.Name }}s(rows)
+	{{- end }}
 }	
 {{ end }}
 
 {{end}}`))
 
-	templateTest = template.Must(template.New("").Funcs(orm.FnMap).Parse(`
+	templateTest = template.Must(template.New("").Funcs(fnMap).Parse(`
 func queries{{.Name}}(tx *sql.Tx, item {{.Name}}) ({{.Name}}, error) {
-	{{ if .HasID }} item, err := item.Insert(tx)
-	{{ else }} err := InsertMany{{ .Name }}s(tx, item) {{end}}
+	{{- with .CtxArg }}
+	ctx := context.Background()
+	{{- end }}
+	{{ if .HasID }} item, err := item.Insert({{ $.CtxArg }}tx)
+	{{ else }} err := InsertMany{{ .Name }}s({{ $.CtxArg }}tx, item) {{end}}
 	if err != nil {
 		return item, err
 	}
@@ -295,13 +568,49 @@ func queries{{.Name}}(tx *sql.Tx, item {{.Name}}) ({{.Name}}, error) {
 	{{ else }}
 		_ = len(items)
 	{{ end }}
+	count, err := Count{{ .Name }}s({{ $.CtxArg }}tx)
+	if err != nil {
+		return item, err
+	}
+	iterated := 0
+	err = Iter{{ .Name }}s({{ $.CtxArg }}tx, func({{ .Name }}) error {
+		iterated++
+		return nil
+	})
+	if err != nil {
+		return item, err
+	}
+	if iterated != count {
+		return item, fmt.Errorf("inconsistent Count{{ .Name }}s (%d) and Iter{{ .Name }}s (%d)", count, iterated)
+	}
 
 	{{ if .HasID  }}
-	item, err = item.Update(tx)
+	{{- if .HasUpdate }}
+	{{- with .UpdateAssignment.VersionGoName }}
+	stale := item
+	{{- end }}
+	item, err = item.Update({{ $.CtxArg }}tx)
+	if err != nil {
+		return item, err
+	}
+	{{- with .UpdateAssignment.VersionGoName }}
+	// the version has changed : a second update must be rejected
+	if _, err = stale.Update({{ $.CtxArg }}tx); err == nil {
+		return item, errors.New("concurrent update not detected")
+	} else if _, isConflict := err.(ErrConcurrentUpdate); !isConflict {
+		return item, err
+	}
+	{{- end }}
+	{{- end }}
+	_, err = Select{{ .Name }}({{ $.CtxArg }}tx, item.{{ .IDField.GoName }})
+	{{ else if .HasCompositeKey }}
+	{{- if .HasUpdate }}
+	item, err = item.Update({{ $.CtxArg }}tx)
 	if err != nil {
 		return item, err
 	}
-	_, err = Select{{ .Name }}(tx, item.Id)
+	{{- end }}
+	_, err = Select{{ .Name }}ByKey({{ $.CtxArg }}tx, item.Key())
 	{{ else }} 
 	row := tx.QueryRow(` + "`" + `SELECT * FROM {{snake .Name}}s WHERE 
 		{{range $i, $e := .Fields.ForeignKeys }}{{if $i}} AND {{end}}
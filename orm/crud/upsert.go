@@ -0,0 +1,233 @@
+package crud
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"text/template"
+
+	"github.com/benoitkugler/structgen/orm"
+)
+
+// upsert is an INSERT ... ON CONFLICT (<target>) DO UPDATE statement
+type upsert struct {
+	FuncName string
+	Target   []orm.SQLField
+	Set      string // SET clause
+}
+
+// tableUpserts is the data used by templateUpsert
+type tableUpserts struct {
+	structSQL
+	Upserts []upsert
+}
+
+// targetSet identifies the conflict target, regardless of the order of the columns.
+func (u upsert) targetSet() string {
+	cols := make([]string, len(u.Target))
+	for i, f := range u.Target {
+		cols[i] = f.SQLName
+	}
+	sort.Strings(cols)
+	return strings.Join(cols, ",")
+}
+
+// TargetColumns returns the comma separated conflict target.
+func (u upsert) TargetColumns() string {
+	cols := make([]string, len(u.Target))
+	for i, f := range u.Target {
+		cols[i] = f.SQLName
+	}
+	return strings.Join(cols, ", ")
+}
+
+// newUpsert builds the SET clause, which copies the inserted values of the
+// columns not in `target`. The audit and version columns are handled as for
+// Update, and a soft deleted row is restored.
+func (m structSQL) newUpsert(funcName string, target []orm.SQLField) upsert {
+	isTarget := map[string]bool{}
+	for _, f := range target {
+		isTarget[f.SQLName] = true
+	}
+	var set []string
+	for _, f := range m.Fields.Exported().NoId() {
+		switch {
+		case isTarget[f.SQLName], f.IsCreatedAt():
+			continue
+		case f.IsSoftDelete():
+			set = append(set, f.SQLName+" = NULL")
+		case f.IsVersion():
+			set = append(set, fmt.Sprintf("%s = %s.%s + 1", f.SQLName, m.TableName(), f.SQLName))
+		default:
+			set = append(set, fmt.Sprintf("%s = EXCLUDED.%s", f.SQLName, f.SQLName))
+		}
+	}
+	if len(set) == 0 {
+		// DO NOTHING would not return the conflicting row :
+		// use a no-op update instead
+		set = append(set, fmt.Sprintf("%s = EXCLUDED.%s", target[0].SQLName, target[0].SQLName))
+	}
+	return upsert{FuncName: funcName, Target: target, Set: strings.Join(set, ", ")}
+}
+
+// hasUniqueKey returns `true` if `keys` is one of the `uniqueKeys`,
+// in any order.
+func hasUniqueKey(uniqueKeys [][]string, keys []orm.SQLField) bool {
+	for _, key := range uniqueKeys {
+		if len(key) != len(keys) {
+			continue
+		}
+		columns := map[string]bool{}
+		for _, col := range key {
+			columns[col] = true
+		}
+		match := true
+		for _, f := range keys {
+			match = match && columns[f.SQLName]
+		}
+		if match {
+			return true
+		}
+	}
+	return false
+}
+
+// upserts returns the upserts of the table, whose conflict targets
+// are the link keys (for tables without ID) and the given `uniqueKeys`.
+// Since the conflict target requires a matching constraint, the link keys are
+// only used if they are the primary key, or are declared unique.
+// Keys refering to unknown columns are ignored.
+func (m structSQL) upserts(uniqueKeys [][]string) tableUpserts {
+	out := tableUpserts{structSQL: m}
+	seen := map[string]bool{}
+	if !m.HasID() {
+		if keys := m.LinkKeys(); len(keys) != 0 && (m.HasCompositeKey() || hasUniqueKey(uniqueKeys, keys)) {
+			u := m.newUpsert("Upsert"+m.Name, keys)
+			seen[u.targetSet()] = true
+			out.Upserts = append(out.Upserts, u)
+		}
+	}
+	byName := map[string]orm.SQLField{}
+	for _, f := range m.Fields {
+		byName[f.SQLName] = f
+	}
+	for _, key := range uniqueKeys {
+		var (
+			target  []orm.SQLField
+			goNames string
+		)
+		for _, col := range key {
+			field, ok := byName[col]
+			if !ok {
+				target = nil
+				break
+			}
+			target = append(target, field)
+			goNames += field.GoName
+		}
+		if target == nil {
+			continue
+		}
+		u := m.newUpsert("Upsert"+m.Name+"By"+goNames, target)
+		if seen[u.targetSet()] {
+			continue
+		}
+		seen[u.targetSet()] = true
+		out.Upserts = append(out.Upserts, u)
+	}
+	return out
+}
+
+var templateUpsert = template.Must(template.New("").Funcs(fnMap).Parse(`
+{{- $insert := .InsertAssignment }}
+{{- range .Upserts }}
+// {{ .FuncName }} inserts the item, or updates the row
+// with the same ({{ .TargetColumns }}){{ if $.HasID }}, and returns the item with id filled{{ end }}.
+{{- if $.HasID }}
+func {{ .FuncName }}({{ $.CtxParam }}tx DB, item {{ $.Name }}) ({{ $.Name }}, error) {
+	row := {{ $.Call "QueryRow" .FuncName }}` + "`" + `INSERT INTO {{ snake $.Name }}s (
+		{{ $insert.Columns }}
+		) VALUES (
+		{{ $insert.Values }}
+		) ON CONFLICT ({{ .TargetColumns }}) DO UPDATE SET {{ .Set }}
+		RETURNING *;
+		` + "`" + `{{ $insert.Args }})
+	{{- if $.HasOutbox }}
+	out, err := Scan{{ $.Name }}(row)
+	if err != nil {
+		return out, err
+	}
+	{{ $.WriteOutbox "UPSERT" "out" "nil" "out" }}
+	return out, err
+	{{- else }}
+	return Scan{{ $.Name }}(row)
+	{{- end }}
+}
+{{- else }}
+func {{ .FuncName }}({{ $.CtxParam }}tx DB, item {{ $.Name }}) error {
+	{{- if $.HasOutbox }}
+	row := {{ $.Call "QueryRow" .FuncName }}` + "`" + `INSERT INTO {{ snake $.Name }}s (
+		{{ $insert.Columns }}
+		) VALUES (
+		{{ $insert.Values }}
+		) ON CONFLICT ({{ .TargetColumns }}) DO UPDATE SET {{ .Set }}
+		RETURNING *;
+		` + "`" + `{{ $insert.Args }})
+	out, err := Scan{{ $.Name }}(row)
+	if err != nil {
+		return err
+	}
+	{{ $.WriteOutbox "UPSERT" "out" "nil" "out" }}
+	{{- else }}
+	_, err := {{ $.Call "Exec" .FuncName }}` + "`" + `INSERT INTO {{ snake $.Name }}s (
+		{{ $insert.Columns }}
+		) VALUES (
+		{{ $insert.Values }}
+		) ON CONFLICT ({{ .TargetColumns }}) DO UPDATE SET {{ .Set }};
+		` + "`" + `{{ $insert.Args }})
+	{{- end }}
+	return err
+}
+{{- end }}
+{{ end }}
+
+{{- if .Upserts }}
+// InsertMany{{ .Name }}sOnConflictDoNothing inserts the items,
+// ignoring the ones conflicting with an existing row.
+func InsertMany{{ .Name }}sOnConflictDoNothing({{ $.CtxParam }}tx DB, items ...{{ .Name }}) error {
+	if len(items) == 0 {
+		return nil
+	}
+
+	stmt, err := {{ $.Call "Prepare" (print "InsertMany" $.Name "sOnConflictDoNothing") }}` + "`" + `INSERT INTO {{ snake .Name }}s (
+		{{ $insert.Columns }}
+		) VALUES (
+		{{ $insert.Values }}
+		) ON CONFLICT DO NOTHING{{ if .HasOutbox }} RETURNING *{{ end }};
+		` + "`" + `)
+	if err != nil {
+		return err
+	}
+
+	for _, item := range items {
+		{{- if .HasOutbox }}
+		inserted, err := Scan{{ .Name }}({{ $.StmtQueryRow }}{{ if $insert.Args }}{{ slice $insert.Args 1 }}{{ end }}))
+		if err == sql.ErrNoRows { // conflicting item
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		{{ .WriteOutbox "INSERT" "inserted" "nil" "inserted" }}
+		{{- else }}
+		_, err = {{ $.StmtExec }}{{ if $insert.Args }}{{ slice $insert.Args 1 }}{{ end }})
+		{{- end }}
+		if err != nil {
+			return err
+		}
+	}
+
+	return stmt.Close()
+}
+{{ end }}
+`))
@@ -0,0 +1,151 @@
+package orm
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/benoitkugler/structgen/loader"
+	"github.com/benoitkugler/structgen/orm/sqltypes"
+)
+
+// Index is a CREATE INDEX statement, defined either
+// by a `sql_index` field tag, or by a special comment
+//
+//	// sql_index: [UNIQUE] [USING <method>] (<col1>, <col2>, ...) [WHERE <predicate>]
+//
+// Enums value, defined as #<TypeName>.<VarName> are replaced by their content
+// in the predicate.
+type Index struct {
+	sqlTable string
+	Columns  []string
+	Method   string // optional, such as btree or gin
+	Unique   bool
+	Where    string // optional predicate, for partial indexes
+
+	foreignKey bool // automatic index on a foreign key
+}
+
+// Table returns the SQL table name.
+func (ix Index) Table() string { return ix.sqlTable }
+
+// Name returns the name of the index, built from
+// the table and the indexed columns. Foreign key indexes
+// use a distinct suffix, so that they never clash with a
+// partial index on the same column.
+func (ix Index) Name() string {
+	suffix := "idx"
+	if ix.foreignKey {
+		suffix = "fkey_idx"
+	}
+	return fmt.Sprintf("%s_%s_%s", ix.sqlTable, strings.Join(ix.Columns, "_"), suffix)
+}
+
+func (ix Index) Render() string {
+	unique, using, where := "", "", ""
+	if ix.Unique {
+		unique = "UNIQUE "
+	}
+	if ix.Method != "" {
+		using = " USING " + ix.Method
+	}
+	if ix.Where != "" {
+		where = " WHERE " + ix.Where
+	}
+	return fmt.Sprintf("CREATE %sINDEX %s ON %s%s (%s)%s;",
+		unique, ix.Name(), ix.sqlTable, using, strings.Join(ix.Columns, ", "), where)
+}
+
+// IsUniqueColumn returns the column made unique by the index,
+// or the empty string.
+// Partial indexes do not garantee unicity and are ignored.
+func (ix Index) IsUniqueColumn() string {
+	if cols := ix.UniqueColumns(); len(cols) == 1 {
+		return cols[0]
+	}
+	return ""
+}
+
+// UniqueColumns returns the columns made unique by the index,
+// or nil. As for IsUniqueColumn, partial indexes are ignored.
+func (ix Index) UniqueColumns() []string {
+	if ix.Unique && ix.Where == "" {
+		return ix.Columns
+	}
+	return nil
+}
+
+var reIndex = regexp.MustCompile(`(?is)^\s*(UNIQUE\s+)?(USING\s+(\w+)\s*)?\(([^)]+)\)\s*(WHERE\s+(.+))?$`)
+
+// NewIndex parses a `sql_index` comment.
+func NewIndex(c loader.Comment, lookupTable map[string]string) (Index, error) {
+	match := reIndex.FindStringSubmatch(c.Content)
+	if match == nil {
+		return Index{}, fmt.Errorf("invalid index definition for %s : %s", c.TypeName, c.Content)
+	}
+	where, err := parseComment(strings.TrimSpace(match[6]), lookupTable)
+	if err != nil {
+		return Index{}, err
+	}
+	out := Index{
+		sqlTable: tableName(c.TypeName),
+		Unique:   match[1] != "",
+		Method:   strings.ToLower(match[3]),
+		Where:    strings.TrimSuffix(where, ";"),
+	}
+	for _, col := range strings.Split(match[4], ",") {
+		out.Columns = append(out.Columns, strings.TrimSpace(col))
+	}
+	return out, nil
+}
+
+var indexMethods = map[string]bool{
+	"btree": true, "hash": true, "gist": true, "spgist": true, "gin": true, "brin": true,
+}
+
+// Index returns the index defined by a `sql_index` tag,
+// whose value is a comma separated list of options :
+//   - unique
+//   - an index method, such as btree or gin
+//   - where=<predicate>, for a partial index, which must be the last option
+//
+// JSONB columns are indexed with gin by default.
+// An error is returned for unknown options.
+func (s SQLField) Index(tableGoName string) (Index, bool, error) {
+	options, has := s.goTag.Lookup("sql_index")
+	if !has {
+		return Index{}, false, nil
+	}
+	out := Index{sqlTable: tableName(tableGoName), Columns: []string{s.SQLName}}
+	if s.Type.Type == sqltypes.JSONB {
+		out.Method = "gin"
+	}
+	for options != "" {
+		var option string
+		if strings.HasPrefix(options, "where=") {
+			option, options = options, ""
+		} else if i := strings.IndexByte(options, ','); i != -1 {
+			option, options = options[:i], options[i+1:]
+		} else {
+			option, options = options, ""
+		}
+		option = strings.TrimSpace(option)
+		switch {
+		case option == "unique":
+			out.Unique = true
+		case indexMethods[option]:
+			out.Method = option
+		case strings.HasPrefix(option, "where="):
+			out.Where = strings.TrimPrefix(option, "where=")
+		case option == "":
+		default:
+			return Index{}, true, fmt.Errorf("invalid sql_index option %q for %s.%s", option, tableGoName, s.GoName)
+		}
+	}
+	return out, true, nil
+}
+
+// ForeignKeyIndex returns a plain index on the foreign key column.
+func (s SQLField) ForeignKeyIndex(tableGoName string) Index {
+	return Index{sqlTable: tableName(tableGoName), Columns: []string{s.SQLName}, foreignKey: true}
+}
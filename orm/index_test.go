@@ -0,0 +1,49 @@
+package orm
+
+import (
+	"go/types"
+	"testing"
+
+	"github.com/benoitkugler/structgen/loader"
+)
+
+func TestIndex(t *testing.T) {
+	for _, test := range []struct {
+		content  string
+		expected string
+	}{
+		{"(id_user)", "CREATE INDEX user_groups_id_user_idx ON user_groups (id_user);"},
+		{"UNIQUE (id_user, id_group)", "CREATE UNIQUE INDEX user_groups_id_user_id_group_idx ON user_groups (id_user, id_group);"},
+		{"USING gin (data)", "CREATE INDEX user_groups_data_idx ON user_groups USING gin (data);"},
+		{"unique (id_user) WHERE kind = #Kind.Admin", "CREATE UNIQUE INDEX user_groups_id_user_idx ON user_groups (id_user) WHERE kind = 2;"},
+	} {
+		ix, err := NewIndex(loader.Comment{TypeName: "UserGroup", Content: test.content}, map[string]string{"Kind.Admin": "2"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := ix.Render(); got != test.expected {
+			t.Fatalf("expected %s, got %s", test.expected, got)
+		}
+	}
+
+	if _, err := NewIndex(loader.Comment{Content: "id_user"}, nil); err == nil {
+		t.Fatal("expected error on missing parenthesis")
+	}
+}
+
+func TestFieldIndex(t *testing.T) {
+	table := NewGoSQLTable("UserGroup", newStruct([]string{"IdUser", "IdGroup"},
+		[]types.Type{types.Typ[types.Int64], types.Typ[types.Int64]},
+		[]string{`json:"id_user" sql_index:"unique,where=id_user > 0"`, `json:"id_group" sql_index:"uniq"`}), nil)
+
+	ix, has, err := table.Fields[0].Index(table.Name)
+	if !has || err != nil {
+		t.Fatal(err)
+	}
+	if fk := table.Fields[0].ForeignKeyIndex(table.Name); fk.Name() == ix.Name() {
+		t.Fatal("foreign key index name clashes with", ix.Name())
+	}
+	if _, _, err = table.Fields[1].Index(table.Name); err == nil {
+		t.Fatal("expected error on unknown option")
+	}
+}
@@ -0,0 +1,337 @@
+package jsonsql
+
+import (
+	"fmt"
+	"hash/fnv"
+	"log"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/benoitkugler/structgen/loader"
+)
+
+// rule is one value constraint of a `validate` tag.
+// For numbers, min and max apply to the value; for strings, arrays
+// and maps, they apply to the length, like the len comparisons.
+type rule struct {
+	name   string // min, max, len, pattern, nonempty
+	op     string // comparison operator, for len
+	arg    string
+	format string // email or url, for the patterns they define
+}
+
+// formatPatterns are the patterns of the email and url rules.
+var formatPatterns = map[string]string{
+	"email": `^[^@\s]+@[^@\s]+\.[^@\s]+$`,
+	"url":   `^https?://[^\s/]+\S*$`,
+}
+
+var lenOperators = [...]string{"<=", ">=", "<", ">", "="} // longest first
+
+// checkPattern returns an error if `pattern` is not a valid Go regular expression,
+// or uses a construct which PostgreSQL does not support, or interprets differently,
+// such as \b (a backspace in PostgreSQL) or the named groups.
+func checkPattern(pattern string) error {
+	if _, err := regexp.Compile(pattern); err != nil {
+		return fmt.Errorf("invalid validate pattern %s: %s", pattern, err)
+	}
+	for i := 0; i < len(pattern)-1; i++ {
+		switch {
+		case pattern[i] == '\\':
+			if strings.IndexByte("bBzQEpPC", pattern[i+1]) != -1 {
+				return fmt.Errorf("validate pattern %s: \\%c is not supported by PostgreSQL", pattern, pattern[i+1])
+			}
+			i++ // skip the escaped character
+		case pattern[i] == '(' && pattern[i+1] == '?' && !strings.HasPrefix(pattern[i:], "(?:"):
+			return fmt.Errorf("validate pattern %s: flags and named groups are not supported by PostgreSQL", pattern)
+		}
+	}
+	return nil
+}
+
+// parseRules parses a tag such as `min=0,max=100`, `len<=200`,
+// `pattern=^[A-Z]+$`, `email`, `url` or `nonempty`. Since a pattern may contain commas,
+// it must be the last rule. It is checked by both Go and PostgreSQL, and so must be
+// understood the same way by the two (see checkPattern).
+func parseRules(tag string) ([]rule, error) {
+	var out []rule
+	parts := strings.Split(tag, ",")
+	for i, part := range parts {
+		part = strings.TrimSpace(part)
+		switch {
+		case part == "":
+		case strings.HasPrefix(part, "pattern="):
+			pattern := strings.TrimPrefix(strings.Join(parts[i:], ","), "pattern=")
+			if err := checkPattern(pattern); err != nil {
+				return nil, err
+			}
+			return append(out, rule{name: "pattern", arg: pattern}), nil
+		case part == "nonempty":
+			out = append(out, rule{name: "nonempty"})
+		case formatPatterns[part] != "":
+			out = append(out, rule{name: "pattern", arg: formatPatterns[part], format: part})
+		case strings.HasPrefix(part, "min="), strings.HasPrefix(part, "max="):
+			arg := part[len("min="):]
+			if _, err := strconv.ParseFloat(arg, 64); err != nil {
+				return nil, fmt.Errorf("invalid validate rule %s: %s", part, err)
+			}
+			out = append(out, rule{name: part[:len("min")], arg: arg})
+		case strings.HasPrefix(part, "len"):
+			r := rule{name: "len"}
+			for _, op := range lenOperators {
+				if strings.HasPrefix(part[len("len"):], op) {
+					r.op, r.arg = op, part[len("len")+len(op):]
+					break
+				}
+			}
+			if _, err := strconv.Atoi(r.arg); r.op == "" || err != nil {
+				return nil, fmt.Errorf("invalid validate rule %s", part)
+			}
+			out = append(out, r)
+		default:
+			return nil, fmt.Errorf("unknown validate rule %s", part)
+		}
+	}
+	return out, nil
+}
+
+// constrained adds the rules of a `validate` tag to
+// the validation of its element.
+type constrained struct {
+	elem  TypeJSON
+	tag   string
+	rules []rule
+}
+
+// newConstrained returns `elem` if the tag is empty or invalid.
+func newConstrained(elem TypeJSON, tag string) TypeJSON {
+	if tag == "" {
+		return elem
+	}
+	rules, err := parseRules(tag)
+	if err != nil {
+		log.Printf("warning : %s, validate tag ignored", err)
+		return elem
+	}
+	return constrained{elem: elem, tag: tag, rules: rules}
+}
+
+func (c constrained) Id() string {
+	h := fnv.New32a()
+	h.Write([]byte(c.tag))
+	return fmt.Sprintf("%s_%08x", c.elem.Id(), h.Sum32())
+}
+
+// jsonKind returns the JSON type of the values accepted by `t`,
+// or an empty string
+func jsonKind(t TypeJSON) string {
+	switch t := t.(type) {
+	case basic:
+		return string(t)
+	case enumValue:
+		return string(t.basic)
+	case Array:
+		return "array"
+	case Map, *class:
+		return "object"
+	case constrained:
+		return jsonKind(t.elem)
+	default:
+		return ""
+	}
+}
+
+// lengthSQL returns the expression computing the length of `data`,
+// where null arrays and maps have a zero length.
+func lengthSQL(kind string) string {
+	switch kind {
+	case "string":
+		return "char_length(data#>>'{}')"
+	case "array":
+		return "COALESCE(jsonb_array_length(NULLIF(data, 'null')), 0)"
+	case "object":
+		return "(SELECT count(*) FROM jsonb_object_keys(NULLIF(data, 'null')))"
+	default:
+		return ""
+	}
+}
+
+// sql returns the condition checked by the rule, or an empty
+// string if the rule does not apply to `kind`.
+func (r rule) sql(kind string) string {
+	length := lengthSQL(kind)
+	switch r.name {
+	case "min", "max":
+		op := map[string]string{"min": ">=", "max": "<="}[r.name]
+		if kind == "number" {
+			return fmt.Sprintf("data::numeric %s %s", op, r.arg)
+		} else if length != "" {
+			return fmt.Sprintf("%s %s %s", length, op, r.arg)
+		}
+	case "len":
+		if length != "" {
+			return fmt.Sprintf("%s %s %s", length, r.op, r.arg)
+		}
+	case "nonempty":
+		if kind == "number" {
+			return "data::numeric <> 0"
+		} else if length != "" {
+			return length + " > 0"
+		}
+	case "pattern":
+		if kind == "string" {
+			return fmt.Sprintf("data#>>'{}' ~ '%s'", strings.ReplaceAll(r.arg, "'", "''"))
+		}
+	}
+	return ""
+}
+
+// problem describes a value of type `kind` violating the rule.
+func (r rule) problem(kind string) string {
+	switch r.name {
+	case "min", "max":
+		op := map[string]string{"min": ">=", "max": "<="}[r.name]
+		if kind == "number" {
+			return fmt.Sprintf("must be %s %s", op, r.arg)
+		}
+		return fmt.Sprintf("length must be %s %s", op, r.arg)
+	case "len":
+		return fmt.Sprintf("length must be %s %s", r.op, r.arg)
+	case "nonempty":
+		if kind == "number" {
+			return "must not be zero"
+		}
+		return "must not be empty"
+	default:
+		if r.format != "" {
+			return "must be a valid " + r.format
+		}
+		return "must match " + r.arg
+	}
+}
+
+// Limits are the bounds set by a `validate` tag,
+// used to generate valid values.
+type Limits struct {
+	Min, Max       *float64 // value, for numbers
+	MinLen, MaxLen int      // length, for strings, arrays and maps ; MaxLen is -1 if not bounded
+	NonZero        bool     // for numbers
+	Format         string   // email or url
+	Pattern        string   // other pattern
+}
+
+// NoLimits does not restrict the values.
+var NoLimits = Limits{MaxLen: -1}
+
+// ParseLimits returns the bounds defined by `tag`, for numbers
+// or for values with a length.
+func ParseLimits(tag string, isNumber bool) (Limits, error) {
+	rules, err := parseRules(tag)
+	if err != nil {
+		return NoLimits, err
+	}
+	out := NoLimits
+	for _, r := range rules {
+		switch r.name {
+		case "min", "max":
+			v, _ := strconv.ParseFloat(r.arg, 64) // checked by parseRules
+			if isNumber {
+				out.Restrict(r.name, v)
+			} else {
+				out.RestrictLen(map[string]string{"min": ">=", "max": "<="}[r.name], int(v))
+			}
+		case "len":
+			n, _ := strconv.Atoi(r.arg)
+			out.RestrictLen(r.op, n)
+		case "nonempty":
+			if isNumber {
+				out.NonZero = true
+			} else {
+				out.RestrictLen(">=", 1)
+			}
+		case "pattern":
+			if r.format != "" {
+				out.Format = r.format
+			} else {
+				out.Pattern = r.arg
+			}
+		}
+	}
+	return out, nil
+}
+
+// Restrict applies a min or max bound on the value.
+func (l *Limits) Restrict(bound string, v float64) {
+	if bound == "min" && (l.Min == nil || *l.Min < v) {
+		l.Min = &v
+	} else if bound == "max" && (l.Max == nil || *l.Max > v) {
+		l.Max = &v
+	}
+}
+
+// RestrictLen applies a comparison on the length.
+func (l *Limits) RestrictLen(op string, n int) {
+	switch op {
+	case "<":
+		n--
+		fallthrough
+	case "<=":
+		if l.MaxLen == -1 || l.MaxLen > n {
+			l.MaxLen = n
+		}
+	case ">":
+		n++
+		fallthrough
+	case ">=":
+		if l.MinLen < n {
+			l.MinLen = n
+		}
+	case "=":
+		l.RestrictLen("<=", n)
+		l.RestrictLen(">=", n)
+	}
+}
+
+const vConstrained = `
+	CREATE OR REPLACE FUNCTION %s (data jsonb)
+		RETURNS boolean
+		AS $$
+	DECLARE
+		is_valid boolean := %s(data);
+	BEGIN
+		IF NOT is_valid THEN
+			RETURN FALSE;
+		END IF;
+		-- the value has the expected type : the casts are safe
+		is_valid := %s;
+		IF NOT is_valid THEN
+			RAISE WARNING '%% does not satisfy %s', data;
+		END IF;
+		RETURN is_valid;
+	END;
+	$$
+	LANGUAGE 'plpgsql'
+	IMMUTABLE;`
+
+func (c constrained) Validations() []loader.Declaration {
+	out := c.elem.Validations() // recursion
+
+	kind := jsonKind(c.elem)
+	var checks []string
+	for _, r := range c.rules {
+		check := r.sql(kind)
+		if check == "" {
+			log.Printf("warning : validate rule %s is not supported for %s, ignored", r.name, c.elem.Id())
+			continue
+		}
+		checks = append(checks, "("+check+")")
+	}
+	if len(checks) == 0 {
+		checks = []string{"TRUE"}
+	}
+	message := strings.ReplaceAll(strings.ReplaceAll(c.tag, "'", "''"), "%", "%%")
+	fn := FunctionName(c)
+	content := fmt.Sprintf(vConstrained, fn, FunctionName(c.elem), strings.Join(checks, " AND "), message)
+	return append(out, loader.Declaration{Id: fn, Content: content})
+}
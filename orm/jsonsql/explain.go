@@ -0,0 +1,181 @@
+package jsonsql
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/benoitkugler/structgen/loader"
+)
+
+// ExplainFunctionName returns the name of the function listing
+// the problems of a value of type `t`
+func ExplainFunctionName(t TypeJSON) string {
+	return "structgen_explain_json_" + t.Id()
+}
+
+// The explain functions return one row per problem, with the path of the
+// faulty value (such as $.items[2].name), and are empty for valid values.
+// They accept an additional 'root' argument, used for nested values.
+const vExplain = `
+	CREATE OR REPLACE FUNCTION %s (data jsonb, root text DEFAULT '$')
+		RETURNS TABLE (path text, problem text)
+		AS $$
+	BEGIN
+		%s
+	END;
+	$$
+	LANGUAGE 'plpgsql'
+	IMMUTABLE;`
+
+func explainDeclaration(t TypeJSON, body string) loader.Declaration {
+	fn := ExplainFunctionName(t)
+	return loader.Declaration{Id: fn, Content: fmt.Sprintf(vExplain, fn, body)}
+}
+
+// report returns a plpgsql statement adding a problem, given as
+// SQL expression, at `root`
+func report(problem string) string {
+	return fmt.Sprintf("path := root; problem := %s; RETURN NEXT;", problem)
+}
+
+func quote(s string) string { return "'" + strings.ReplaceAll(s, "'", "''") + "'" }
+
+// explainKind returns a plpgsql statement reporting a value
+// not of the JSON type `kind`, and stopping the function.
+func explainKind(kind string) string {
+	return fmt.Sprintf(`IF jsonb_typeof(data) IS DISTINCT FROM '%s' THEN
+			%s
+			RETURN;
+		END IF;`, kind, report(fmt.Sprintf("'expected %s, got ' || COALESCE(jsonb_typeof(data), 'nothing')", kind)))
+}
+
+// explainNested returns a plpgsql statement adding the problems of
+// the value `value` of type `t`, at `path`
+func explainNested(t TypeJSON, value, path string) string {
+	return fmt.Sprintf("RETURN QUERY SELECT * FROM %s(%s, %s);", ExplainFunctionName(t), value, path)
+}
+
+const explainNull = "IF jsonb_typeof(data) = 'null' THEN RETURN; END IF;"
+
+func (b basic) Explanations() []loader.Declaration {
+	body := "RETURN;" // Dynamic accepts anything
+	if b != Dynamic {
+		body = explainKind(string(b))
+	}
+	return []loader.Declaration{explainDeclaration(b, body)}
+}
+
+func (b enumValue) Explanations() []loader.Declaration {
+	typeCast := `data#>>'{}'`
+	if b.basic == Number {
+		typeCast = "data::int"
+	}
+	body := explainKind(string(b.basic)) + fmt.Sprintf(`
+		IF %s NOT IN %s THEN
+			%s
+		END IF;`, typeCast, b.enumType.AsTuple(), report(fmt.Sprintf("data::text || ' is not a %s'", b.enumType.Name)))
+	return []loader.Declaration{explainDeclaration(b, body)}
+}
+
+func (b Array) Explanations() []loader.Declaration {
+	out := b.elem.Explanations() // recursion
+
+	var body string
+	if b.length == -1 { // accepts null
+		body = explainNull + "\n"
+	}
+	body += explainKind("array")
+	if b.length >= 0 {
+		body += fmt.Sprintf(`
+		IF jsonb_array_length(data) != %d THEN
+			%s
+			RETURN;
+		END IF;`, b.length, report(fmt.Sprintf("'expected %d elements, got ' || jsonb_array_length(data)", b.length)))
+	}
+	body += fmt.Sprintf(`
+		RETURN QUERY SELECT problems.* FROM jsonb_array_elements(data) WITH ORDINALITY AS elems (value, index),
+			LATERAL %s(elems.value, root || '[' || (elems.index - 1) || ']') AS problems;`, ExplainFunctionName(b.elem))
+	return append(out, explainDeclaration(b, body))
+}
+
+func (b Map) Explanations() []loader.Declaration {
+	out := b.elem.Explanations() // recursion
+
+	body := explainNull + "\n" + explainKind("object") + fmt.Sprintf(`
+		RETURN QUERY SELECT problems.* FROM jsonb_each(data) AS entries,
+			LATERAL %s(entries.value, root || '[' || to_json(entries.key)::text || ']') AS problems;`, ExplainFunctionName(b.elem))
+	return append(out, explainDeclaration(b, body))
+}
+
+func (b *class) Explanations() (out []loader.Declaration) {
+	if b.explainRenderCache[b] {
+		return nil
+	}
+	b.explainRenderCache[b] = true
+
+	var keys, checks []string
+	for _, f := range b.fields {
+		out = append(out, f.type_.Explanations()...) // recursion
+		keys = append(keys, quote(f.key))
+		checks = append(checks, fmt.Sprintf(`IF NOT data ? %s THEN
+			%s
+		ELSE
+			%s
+		END IF;`, quote(f.key), report(quote("missing key "+f.key)),
+			explainNested(f.type_, fmt.Sprintf("data->%s", quote(f.key)), fmt.Sprintf("root || %s", quote("."+f.key)))))
+	}
+	keyCheck := "TRUE"
+	if len(keys) != 0 {
+		keyCheck = "key NOT IN (" + strings.Join(keys, ", ") + ")"
+	}
+	body := explainKind("object") + fmt.Sprintf(`
+		RETURN QUERY SELECT root, 'unexpected key ' || key FROM jsonb_object_keys(data) AS key WHERE %s;
+		%s`, keyCheck, strings.Join(checks, "\n"))
+	return append(out, explainDeclaration(b, body))
+}
+
+func (u union) Explanations() (out []loader.Declaration) {
+	var cases []string
+	for _, member := range u.members {
+		cases = append(cases, fmt.Sprintf("WHEN %s THEN\n %s", quote(member.tag),
+			explainNested(member.type_, "data->'Data'", "root || '.Data'")))
+
+		// generate explain function for members
+		out = append(out, member.type_.Explanations()...)
+	}
+	cases = append(cases, "ELSE\n"+report("'unknown kind ' || (data->>'Kind')"))
+	body := explainKind("object") + fmt.Sprintf(`
+		IF jsonb_typeof(data->'Kind') IS DISTINCT FROM 'string' THEN
+			path := root || '.Kind'; problem := 'expected string, got ' || COALESCE(jsonb_typeof(data->'Kind'), 'nothing'); RETURN NEXT;
+			RETURN;
+		END IF;
+		IF jsonb_typeof(data->'Data') IS NULL OR jsonb_typeof(data->'Data') = 'null' THEN
+			path := root || '.Data'; problem := 'missing data'; RETURN NEXT;
+			RETURN;
+		END IF;
+		CASE data->>'Kind'
+			%s
+		END CASE;`, strings.Join(cases, "\n"))
+	return append(out, explainDeclaration(u, body))
+}
+
+func (c constrained) Explanations() []loader.Declaration {
+	out := c.elem.Explanations() // recursion
+
+	kind := jsonKind(c.elem)
+	body := explainNested(c.elem, "data", "root") + `
+		IF FOUND THEN -- the value has not the expected type
+			RETURN;
+		END IF;`
+	for _, r := range c.rules {
+		check := r.sql(kind) // unsupported rules are reported by Validations
+		if check == "" {
+			continue
+		}
+		body += fmt.Sprintf(`
+		IF NOT (%s) THEN
+			%s
+		END IF;`, check, report(quote(r.problem(kind))))
+	}
+	return append(out, explainDeclaration(c, body))
+}
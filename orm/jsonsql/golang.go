@@ -0,0 +1,368 @@
+package jsonsql
+
+import (
+	"fmt"
+	"go/types"
+	"hash/fnv"
+	"strconv"
+	"strings"
+
+	"github.com/benoitkugler/structgen/enums"
+	"github.com/benoitkugler/structgen/loader"
+)
+
+// GoFunctionName returns the name of the Go validation function
+// associated with `t`
+func GoFunctionName(t TypeJSON) string {
+	return "validateJSON_" + t.Id()
+}
+
+// GoHelpers should be included once, before the Go validation functions.
+const GoHelpers = `
+// JSONError reports an invalid JSON value, at Path.
+type JSONError struct {
+	Path    string
+	Problem string
+}
+
+func (e JSONError) Error() string {
+	return fmt.Sprintf("invalid JSON at %s: %s", e.Path, e.Problem)
+}
+
+func decodeJSON(data []byte) (interface{}, error) {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	var out interface{}
+	err := dec.Decode(&out)
+	return out, err
+}
+
+// jsonKind returns the JSON type of a decoded value
+func jsonKind(data interface{}) string {
+	switch data.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "boolean"
+	case json.Number:
+		return "number"
+	case string:
+		return "string"
+	case []interface{}:
+		return "array"
+	case map[string]interface{}:
+		return "object"
+	default:
+		return "unknown"
+	}
+}
+
+// jsonLength returns the length of a string (in characters), array or object,
+// null having a zero length
+func jsonLength(data interface{}) int {
+	switch data := data.(type) {
+	case string:
+		return utf8.RuneCountInString(data)
+	case []interface{}:
+		return len(data)
+	case map[string]interface{}:
+		return len(data)
+	default:
+		return 0
+	}
+}
+
+func jsonNumber(data interface{}) float64 {
+	f, _ := strconv.ParseFloat(string(data.(json.Number)), 64)
+	return f
+}
+`
+
+// goValidation returns a Go validation function for `t`, with `body`
+// checking `data`, located at `path`.
+func goValidation(t TypeJSON, body string) loader.Declaration {
+	fn := GoFunctionName(t)
+	return loader.Declaration{
+		Id: fn,
+		Content: fmt.Sprintf(`
+		func %s(data interface{}, path string) error {
+			%s
+			return nil
+		}
+		`, fn, body),
+	}
+}
+
+// checkKind is a Go statement returning an error if `data`
+// is not of the JSON type `kind`
+func checkKind(kind string) string {
+	return fmt.Sprintf(`if k := jsonKind(data); k != %q {
+		return JSONError{Path: path, Problem: "expected %s, got " + k}
+	}`, kind, kind)
+}
+
+// acceptNull is a Go statement accepting a null value
+const acceptNull = `if data == nil {
+	return nil
+}`
+
+func (b basic) GoValidations() []loader.Declaration {
+	body := ""
+	if b != Dynamic { // Dynamic accepts anything
+		body = checkKind(string(b))
+	}
+	return []loader.Declaration{goValidation(b, body)}
+}
+
+func (b enumValue) GoValidations() []loader.Declaration {
+	// compare the values as Go strings
+	values := make([]string, len(b.enumType.Values))
+	value := "data.(string)"
+	for i, val := range b.enumType.Values {
+		values[i] = val.Value // Go string literal
+		if b.basic == Number {
+			values[i] = strconv.Quote(val.Value)
+		}
+	}
+	if b.basic == Number {
+		value = "string(data.(json.Number))"
+	}
+	body := checkKind(string(b.basic)) + fmt.Sprintf(`
+	switch %s {
+	case %s:
+	default:
+		return JSONError{Path: path, Problem: fmt.Sprintf("%%v is not a %s", data)}
+	}`, value, strings.Join(values, ", "), b.enumType.Name)
+	return []loader.Declaration{goValidation(b, body)}
+}
+
+func (b Array) GoValidations() []loader.Declaration {
+	out := b.elem.GoValidations() // recursion
+
+	var body string
+	if b.length == -1 { // accepts null
+		body = acceptNull + "\n"
+	}
+	body += checkKind("array")
+	if b.length >= 0 {
+		body += fmt.Sprintf(`
+		if l := len(data.([]interface{})); l != %d {
+			return JSONError{Path: path, Problem: fmt.Sprintf("expected %d elements, got %%d", l)}
+		}`, b.length, b.length)
+	}
+	body += fmt.Sprintf(`
+	for i, elem := range data.([]interface{}) {
+		if err := %s(elem, fmt.Sprintf("%%s[%%d]", path, i)); err != nil {
+			return err
+		}
+	}`, GoFunctionName(b.elem))
+	return append(out, goValidation(b, body))
+}
+
+func (b Map) GoValidations() []loader.Declaration {
+	out := b.elem.GoValidations() // recursion
+
+	body := acceptNull + "\n" + checkKind("object") + fmt.Sprintf(`
+	for key, elem := range data.(map[string]interface{}) {
+		if err := %s(elem, fmt.Sprintf("%%s[%%q]", path, key)); err != nil {
+			return err
+		}
+	}`, GoFunctionName(b.elem))
+	return append(out, goValidation(b, body))
+}
+
+func (b *class) GoValidations() (out []loader.Declaration) {
+	if b.goRenderCache[b] {
+		return nil
+	}
+	b.goRenderCache[b] = true
+
+	var keys, checks []string
+	for _, f := range b.fields {
+		out = append(out, f.type_.GoValidations()...) // recursion
+		keys = append(keys, fmt.Sprintf("%q", f.key))
+		checks = append(checks, fmt.Sprintf(`if value, has := object[%q]; !has {
+			return JSONError{Path: path, Problem: "missing key %s"}
+		} else if err := %s(value, path + ".%s"); err != nil {
+			return err
+		}`, f.key, f.key, GoFunctionName(f.type_), f.key))
+	}
+	body := checkKind("object") + fmt.Sprintf(`
+	object := data.(map[string]interface{})
+	for key := range object {
+		switch key {
+		case %s:
+		default:
+			return JSONError{Path: path, Problem: "unexpected key " + key}
+		}
+	}
+	%s`, strings.Join(keys, ", "), strings.Join(checks, "\n"))
+	if len(keys) == 0 {
+		body = checkKind("object") + `
+		if len(data.(map[string]interface{})) != 0 {
+			return JSONError{Path: path, Problem: "expected an empty object"}
+		}`
+	}
+	return append(out, goValidation(b, body))
+}
+
+func (u union) GoValidations() (out []loader.Declaration) {
+	var cases []string
+	for _, member := range u.members {
+		cases = append(cases, fmt.Sprintf(`case %q:
+			err = %s(object["Data"], path + ".Data")`, member.tag, GoFunctionName(member.type_)))
+
+		// generate validation function for members
+		out = append(out, member.type_.GoValidations()...)
+	}
+	body := checkKind("object") + fmt.Sprintf(`
+	object := data.(map[string]interface{})
+	kind, ok := object["Kind"].(string)
+	if !ok {
+		return JSONError{Path: path + ".Kind", Problem: "expected string, got " + jsonKind(object["Kind"])}
+	}
+	if object["Data"] == nil {
+		return JSONError{Path: path + ".Data", Problem: "missing data"}
+	}
+	var err error
+	switch kind {
+	%s
+	default:
+		return JSONError{Path: path + ".Kind", Problem: "unknown kind " + kind}
+	}
+	if err != nil {
+		return err
+	}`, strings.Join(cases, "\n"))
+	return append(out, goValidation(u, body))
+}
+
+// negations of the comparison operators
+var goNegations = map[string]string{"<=": ">", ">=": "<", "<": ">=", ">": "<=", "=": "!="}
+
+// goCheck returns the Go statement checking the rule,
+// or an empty string if the rule does not apply to `kind`
+func (r rule) goCheck(kind string) string {
+	var cond string
+	switch length := lengthSQL(kind) != ""; r.name {
+	case "min", "max":
+		op := goNegations[map[string]string{"min": ">=", "max": "<="}[r.name]]
+		if kind == "number" {
+			cond = fmt.Sprintf("jsonNumber(data) %s %s", op, r.arg)
+		} else if length {
+			cond = fmt.Sprintf("jsonLength(data) %s %s", op, r.arg)
+		}
+	case "len":
+		if length {
+			cond = fmt.Sprintf("jsonLength(data) %s %s", goNegations[r.op], r.arg)
+		}
+	case "nonempty":
+		if kind == "number" {
+			cond = "jsonNumber(data) == 0"
+		} else if length {
+			cond = "jsonLength(data) == 0"
+		}
+	case "pattern":
+		if kind == "string" {
+			cond = fmt.Sprintf("!%s.MatchString(data.(string))", r.goPatternVar())
+		}
+	}
+	if cond == "" {
+		return ""
+	}
+	return fmt.Sprintf(`if %s {
+		return JSONError{Path: path, Problem: %q}
+	}`, cond, r.problem(kind))
+}
+
+func (r rule) goPatternVar() string {
+	h := fnv.New32a()
+	h.Write([]byte(r.arg))
+	return fmt.Sprintf("jsonPattern%08x", h.Sum32())
+}
+
+func (c constrained) GoValidations() []loader.Declaration {
+	out := c.elem.GoValidations() // recursion
+
+	kind := jsonKind(c.elem)
+	body := fmt.Sprintf(`if err := %s(data, path); err != nil {
+		return err
+	}`, GoFunctionName(c.elem))
+	for _, r := range c.rules {
+		check := r.goCheck(kind) // unsupported rules are reported by Validations
+		if check == "" {
+			continue
+		}
+		body += "\n" + check
+		if r.name == "pattern" {
+			out = append(out, loader.Declaration{
+				Id:      r.goPatternVar(),
+				Content: fmt.Sprintf("var %s = regexp.MustCompile(%s)", r.goPatternVar(), strconv.Quote(r.arg)),
+			})
+		}
+	}
+	return append(out, goValidation(c, body))
+}
+
+// GoValidator is a loader.Type rendering the exported
+// Validate<Name> function of a named type.
+type GoValidator struct {
+	name  string
+	type_ TypeJSON
+}
+
+func (v GoValidator) Render() []loader.Declaration {
+	out := v.type_.GoValidations()
+	out = append(out, loader.Declaration{
+		Id: "Validate" + v.name,
+		Content: fmt.Sprintf(`
+		// Validate%s checks that 'data' is a valid JSON encoding of %s.
+		func Validate%s(data []byte) error {
+			value, err := decodeJSON(data)
+			if err != nil {
+				return err
+			}
+			return %s(value, "$")
+		}
+		`, v.name, v.name, v.name, GoFunctionName(v.type_)),
+	})
+	return out
+}
+
+var _ loader.Handler = (*goHandler)(nil)
+
+type goHandler struct {
+	packageName string
+	analyzer    *Analyzer
+}
+
+// NewGoHandler returns a handler generating Go functions
+// validating the JSON encoding of the named types,
+// with the same rules as the SQL validation functions.
+func NewGoHandler(packageName string, enums enums.EnumTable) loader.Handler {
+	return goHandler{packageName: packageName, analyzer: NewAnalyser(enums)}
+}
+
+func (h goHandler) HandleType(typ types.Type) loader.Type {
+	named, ok := typ.(*types.Named)
+	if !ok {
+		return nil
+	}
+	switch h.analyzer.Convert(named).(type) {
+	case basic, enumValue: // not worth a dedicated function
+		return nil
+	}
+	return GoValidator{name: named.Obj().Name(), type_: h.analyzer.Convert(named)}
+}
+
+func (goHandler) HandleComment(loader.Comment) error { return nil }
+
+func (h goHandler) Header() string {
+	return fmt.Sprintf(`package %s
+
+	// Code generated by structgen/jsonsql. DO NOT EDIT
+
+	%s
+	`, h.packageName, GoHelpers)
+}
+
+func (goHandler) Footer() string { return "" }
@@ -0,0 +1,74 @@
+package jsonsql
+
+import (
+	"fmt"
+	"hash/fnv"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/benoitkugler/structgen/loader"
+)
+
+// maxNameLen is the length of the identifiers supported by PostgreSQL,
+// which silently truncates longer names.
+const maxNameLen = 63
+
+// hashedName returns `name` followed by `hash`, shortening `name`
+// so that the hash is kept by PostgreSQL.
+func hashedName(name, hash string) string {
+	if max := maxNameLen - len(hash) - 1; len(name) > max {
+		name = name[:max]
+	}
+	return name + "_" + hash
+}
+
+// ConstraintName returns the name of the constraint of `column`
+// checked by the function `function`, renamed by HashNames. The name
+// keeps the hash of the function.
+func ConstraintName(column, function string) string {
+	i := strings.LastIndexByte(function, '_')
+	return hashedName(column+"_"+function[:i], function[i+1:])
+}
+
+// HashNames renames the functions defined by `decls`, which must be
+// sorted with the nested functions first, by appending a hash of their content.
+// Long names are shortened to keep the hash (see hashedName).
+// Since the content includes the (renamed) nested functions, a function is renamed
+// when its body, or the body of a function it calls, changes.
+// It returns the renamed declarations and the new name of each function.
+func HashNames(decls []loader.Declaration) ([]loader.Declaration, map[string]string) {
+	names := make(map[string]string, len(decls))
+	for _, decl := range decls {
+		if _, has := names[decl.Id]; has {
+			continue
+		}
+		// recursive types reference functions not yet renamed : their
+		// names are hashed as is
+		content := Rename(decl.Content, names)
+		h := fnv.New32a()
+		h.Write([]byte(content))
+		names[decl.Id] = hashedName(decl.Id, fmt.Sprintf("%08x", h.Sum32()))
+	}
+
+	out := make([]loader.Declaration, len(decls))
+	for i, decl := range decls {
+		out[i] = loader.Declaration{Id: names[decl.Id], Content: Rename(decl.Content, names)}
+	}
+	return out, names
+}
+
+// Rename replaces in `code` the old names (keys of `names`) by the new ones,
+// including when used as suffix, as in constraint names.
+func Rename(code string, names map[string]string) string {
+	if len(names) == 0 {
+		return code
+	}
+	olds := make([]string, 0, len(names))
+	for old := range names {
+		olds = append(olds, regexp.QuoteMeta(old))
+	}
+	sort.Slice(olds, func(i, j int) bool { return len(olds[i]) > len(olds[j]) }) // longest first
+	re := regexp.MustCompile(`(` + strings.Join(olds, "|") + `)\b`)
+	return re.ReplaceAllStringFunc(code, func(old string) string { return names[old] })
+}
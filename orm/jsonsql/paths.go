@@ -0,0 +1,50 @@
+package jsonsql
+
+import "go/types"
+
+// Path is a scalar value nested in a JSON object, such as
+// the field B of the field A, accessed with data->'A'->>'B'.
+type Path struct {
+	GoNames []string   // Go field names, from the root
+	Keys    []string   // JSON keys, from the root
+	Go      types.Type // type of the value
+	Kind    basic      // String, Number or Boolean
+}
+
+// Paths returns the scalar values nested in `t`, following the
+// fields of structs. Arrays, maps, interfaces and recursive
+// fields are not traversed.
+func Paths(t TypeJSON) []Path {
+	cl, ok := t.(*class)
+	if !ok {
+		return nil
+	}
+	return cl.paths(nil, nil, map[*class]bool{})
+}
+
+func (b *class) paths(goNames, keys []string, visited map[*class]bool) (out []Path) {
+	visited[b] = true
+	defer delete(visited, b)
+
+	for _, f := range b.fields {
+		names := append(goNames[:len(goNames):len(goNames)], f.goName)
+		fieldKeys := append(keys[:len(keys):len(keys)], f.key)
+		type_ := f.type_
+		if c, ok := type_.(constrained); ok {
+			type_ = c.elem
+		}
+		switch type_ := type_.(type) {
+		case basic:
+			if type_ != Dynamic {
+				out = append(out, Path{GoNames: names, Keys: fieldKeys, Go: f.goType, Kind: type_})
+			}
+		case enumValue:
+			out = append(out, Path{GoNames: names, Keys: fieldKeys, Go: f.goType, Kind: type_.basic})
+		case *class:
+			if !visited[type_] {
+				out = append(out, type_.paths(names, fieldKeys, visited)...)
+			}
+		}
+	}
+	return out
+}
@@ -5,6 +5,7 @@ package jsonsql
 import (
 	"fmt"
 	"go/types"
+	"reflect"
 
 	"github.com/benoitkugler/structgen/enums"
 	"github.com/benoitkugler/structgen/interfaces"
@@ -26,6 +27,13 @@ type TypeJSON interface {
 	// Validation returns the needed declaration for the
 	// function name
 	Validations() []loader.Declaration
+
+	// GoValidations returns the Go equivalent of Validations
+	GoValidations() []loader.Declaration
+
+	// Explanations returns the declarations of the functions
+	// listing the problems of an invalid value
+	Explanations() []loader.Declaration
 }
 
 // FunctionName returns the name of the validation function
@@ -36,16 +44,20 @@ func FunctionName(t TypeJSON) string {
 
 // Analyzer converts a Go type to its json checks.
 type Analyzer struct {
-	enums       enums.EnumTable
-	cache       map[types.Type]TypeJSON
-	renderCache map[TypeJSON]bool
+	enums              enums.EnumTable
+	cache              map[types.Type]TypeJSON
+	renderCache        map[TypeJSON]bool
+	goRenderCache      map[TypeJSON]bool
+	explainRenderCache map[TypeJSON]bool
 }
 
 func NewAnalyser(enums enums.EnumTable) *Analyzer {
 	return &Analyzer{
-		enums:       enums,
-		cache:       make(map[types.Type]TypeJSON),
-		renderCache: make(map[TypeJSON]bool),
+		enums:              enums,
+		cache:              make(map[types.Type]TypeJSON),
+		renderCache:        make(map[TypeJSON]bool),
+		goRenderCache:      make(map[TypeJSON]bool),
+		explainRenderCache: make(map[TypeJSON]bool),
 	}
 }
 
@@ -92,8 +104,10 @@ func (an *Analyzer) create(t types.Type) TypeJSON {
 }
 
 type field struct {
-	type_ TypeJSON
-	key   string
+	type_  TypeJSON
+	key    string
+	goName string
+	goType types.Type
 }
 
 // class is a fixed field struct
@@ -101,13 +115,20 @@ type class struct {
 	name   *types.Named
 	fields []field
 
-	renderCache map[TypeJSON]bool // to handle recursive types
+	renderCache        map[TypeJSON]bool // to handle recursive types
+	goRenderCache      map[TypeJSON]bool // same for GoValidations
+	explainRenderCache map[TypeJSON]bool // same for Explanations
 }
 
 func (an *Analyzer) newStruct(t *types.Struct, name *types.Named) *class {
 	// register the output struct before recursing, to properly handle
 	// recursive types
-	out := &class{name: name, renderCache: an.renderCache}
+	out := &class{
+		name:               name,
+		renderCache:        an.renderCache,
+		goRenderCache:      an.goRenderCache,
+		explainRenderCache: an.explainRenderCache,
+	}
 	an.cache[name] = out
 
 	var fields []field
@@ -117,7 +138,9 @@ func (an *Analyzer) newStruct(t *types.Struct, name *types.Named) *class {
 		if !isExported {
 			continue
 		}
-		fields = append(fields, field{key: key, type_: an.Convert(f.Type())})
+		// the validate tag adds value constraints
+		validate := reflect.StructTag(t.Tag(i)).Get("validate")
+		fields = append(fields, field{key: key, type_: newConstrained(an.Convert(f.Type()), validate), goName: f.Name(), goType: f.Type()})
 	}
 	out.fields = fields
 	return out
@@ -22,7 +22,8 @@ BEGIN
     FROM
         pg_proc
     WHERE
-        starts_with (proname, 'structgen_validate_json')
+        (starts_with (proname, 'structgen_validate_json')
+            OR starts_with (proname, 'structgen_explain_json'))
         AND pg_function_is_visible(oid) INTO func_dropped,
         _sql;
     -- only returned if trailing DROPs succeed
@@ -103,7 +104,7 @@ IMMUTABLE;`
 func (b enumValue) Validations() []loader.Declaration {
 	s := loader.Declaration{Id: FunctionName(b)}
 	typeCast := `data#>>'{}'`
-	if b.enumType.IsInt {
+	if b.basic == Number {
 		typeCast = "data::int"
 	}
 	s.Content = fmt.Sprintf(vEnum, FunctionName(b), string(b.basic), typeCast, b.enumType.AsTuple(), b.Id())
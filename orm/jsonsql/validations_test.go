@@ -1,9 +1,13 @@
 package jsonsql
 
 import (
+	"fmt"
+	"go/format"
 	"go/types"
 	"io/ioutil"
 	"os"
+	"reflect"
+	"strings"
 	"testing"
 
 	"github.com/benoitkugler/structgen/enums"
@@ -52,3 +56,165 @@ func TestItfs(t *testing.T) {
 		}
 	}
 }
+
+func TestParseLimits(t *testing.T) {
+	limits, err := ParseLimits("min=1,max=10,nonempty", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if *limits.Min != 1 || *limits.Max != 10 || !limits.NonZero || limits.MaxLen != -1 {
+		t.Fatal(limits)
+	}
+	limits, err = ParseLimits("max=20,len<10,nonempty,url", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if limits.Min != nil || limits.MinLen != 1 || limits.MaxLen != 9 || limits.Format != "url" {
+		t.Fatal(limits)
+	}
+}
+
+func TestParseRules(t *testing.T) {
+	for _, test := range []struct {
+		tag      string
+		expected []rule
+	}{
+		{"min=0,max=100", []rule{{name: "min", arg: "0"}, {name: "max", arg: "100"}}},
+		{"len<=200", []rule{{name: "len", op: "<=", arg: "200"}}},
+		{"nonempty,pattern=^[A-Z]{1,3}$", []rule{{name: "nonempty"}, {name: "pattern", arg: "^[A-Z]{1,3}$"}}},
+		{"email", []rule{{name: "pattern", arg: formatPatterns["email"], format: "email"}}},
+	} {
+		rules, err := parseRules(test.tag)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(rules, test.expected) {
+			t.Errorf("%s: expected %v, got %v", test.tag, test.expected, rules)
+		}
+	}
+	for _, tag := range []string{"min=a", "len~3", "unknown", "pattern=(a", `pattern=(\w+)\1`, `pattern=\bword\b`, "pattern=(?i)abc", "pattern=(?P<x>a)"} {
+		if _, err := parseRules(tag); err == nil {
+			t.Errorf("%s: expected error", tag)
+		}
+	}
+}
+
+func TestConstraints(t *testing.T) {
+	pkg := types.NewPackage("test", "test")
+	st := types.NewStruct([]*types.Var{
+		types.NewField(0, pkg, "Age", types.Typ[types.Int], false),
+		types.NewField(0, pkg, "Code", types.Typ[types.String], false),
+		types.NewField(0, pkg, "Tags", types.NewSlice(types.Typ[types.String]), false),
+	}, []string{`json:"age" validate:"min=0,max=100"`, `validate:"len<=200,pattern=^[A-Z]+'$"`, `validate:"nonempty"`})
+	named := types.NewNamed(types.NewTypeName(0, pkg, "Person", nil), st, nil)
+
+	code := loader.ToString(NewAnalyser(nil).Convert(named).Validations())
+	for _, check := range []string{
+		"(data::numeric >= 0) AND (data::numeric <= 100)",
+		"(char_length(data#>>'{}') <= 200) AND (data#>>'{}' ~ '^[A-Z]+''$')",
+		"(COALESCE(jsonb_array_length(NULLIF(data, 'null')), 0) > 0)",
+		"RAISE WARNING '% does not satisfy min=0,max=100', data;",
+		"structgen_validate_json_number_",
+	} {
+		if !strings.Contains(code, check) {
+			t.Errorf("missing %s in\n%s", check, code)
+		}
+	}
+}
+
+func TestGoValidations(t *testing.T) {
+	pkg := types.NewPackage("test", "test")
+	level := types.NewNamed(types.NewTypeName(0, pkg, "Level", nil), types.Typ[types.Int], nil)
+	et := enums.EnumTable{"Level": {Name: "Level", Values: []enums.EnumValue{{VarName: "Low", Value: "0"}, {VarName: "High", Value: "1"}}}}
+	st := types.NewStruct([]*types.Var{
+		types.NewField(0, pkg, "Age", types.Typ[types.Int], false),
+		types.NewField(0, pkg, "Code", types.Typ[types.String], false),
+		types.NewField(0, pkg, "Levels", types.NewArray(level, 2), false),
+	}, []string{`json:"age" validate:"min=0,max=100"`, `validate:"pattern=^[A-Z]+$"`, ""})
+	named := types.NewNamed(types.NewTypeName(0, pkg, "Person", nil), st, nil)
+
+	h := NewGoHandler("test", et)
+	decls := h.HandleType(named).Render()
+	code := h.Header() + loader.ToString(decls)
+	if _, err := format.Source([]byte(code)); err != nil {
+		t.Fatal(err, code)
+	}
+	for _, check := range []string{
+		"func ValidatePerson(data []byte) error",
+		`if jsonNumber(data) < 0 {`,
+		`regexp.MustCompile("^[A-Z]+$")`,
+		`case "0", "1":`,
+		`path + ".age"`,
+		`"expected 2 elements, got %d"`,
+	} {
+		if !strings.Contains(code, check) {
+			t.Errorf("missing %s in\n%s", check, code)
+		}
+	}
+
+	if h.HandleType(level) != nil {
+		t.Fatal("enums should not have a dedicated function")
+	}
+}
+
+func TestExplanations(t *testing.T) {
+	pkg := types.NewPackage("test", "test")
+	st := types.NewStruct([]*types.Var{
+		types.NewField(0, pkg, "Age", types.Typ[types.Int], false),
+		types.NewField(0, pkg, "Tags", types.NewSlice(types.Typ[types.String]), false),
+	}, []string{`json:"age" validate:"max=100"`, ""})
+	named := types.NewNamed(types.NewTypeName(0, pkg, "Person", nil), st, nil)
+
+	code := loader.ToString(NewAnalyser(nil).Convert(named).Explanations())
+	for _, check := range []string{
+		"CREATE OR REPLACE FUNCTION structgen_explain_json_tes_Person (data jsonb, root text DEFAULT '$')",
+		"RETURNS TABLE (path text, problem text)",
+		"WHERE key NOT IN ('age', 'Tags')",
+		"path := root; problem := 'missing key age'; RETURN NEXT;",
+		"root || '.Tags'",
+		"root || '[' || (elems.index - 1) || ']'",
+		"path := root; problem := 'must be <= 100'; RETURN NEXT;",
+	} {
+		if !strings.Contains(code, check) {
+			t.Errorf("missing %s in\n%s", check, code)
+		}
+	}
+}
+
+func TestHashNames(t *testing.T) {
+	decls := []loader.Declaration{
+		{Id: "structgen_validate_json_number", Content: "CREATE FUNCTION structgen_validate_json_number () -- v1"},
+		{Id: "structgen_validate_json_number_c0d8bd7b", Content: "CREATE FUNCTION structgen_validate_json_number_c0d8bd7b () structgen_validate_json_number(data)"},
+	}
+	renamed, names := HashNames(decls)
+	if len(renamed) != 2 || renamed[1].Id != names["structgen_validate_json_number_c0d8bd7b"] {
+		t.Fatal(renamed, names)
+	}
+	expected := fmt.Sprintf("CREATE FUNCTION %s () %s(data)", names["structgen_validate_json_number_c0d8bd7b"], names["structgen_validate_json_number"])
+	if renamed[1].Content != expected {
+		t.Fatalf("expected %s, got %s", expected, renamed[1].Content)
+	}
+	if constraint := Rename("opts_structgen_validate_json_number", names); constraint != "opts_"+names["structgen_validate_json_number"] {
+		t.Fatal(constraint)
+	}
+
+	// a change in a nested function is propagated
+	decls[0].Content += " -- v2"
+	_, names2 := HashNames(decls)
+	for name := range names {
+		if names[name] == names2[name] {
+			t.Errorf("%s should be renamed", name)
+		}
+	}
+
+	// long names are shortened, but keep their hash
+	long := "structgen_validate_json_" + strings.Repeat("nested_", 10)
+	_, names = HashNames([]loader.Declaration{{Id: long, Content: "CREATE FUNCTION " + long}})
+	hash := names[long][len(names[long])-8:]
+	if len(names[long]) != 63 || !strings.HasPrefix(long, names[long][:54]) {
+		t.Fatal(names[long])
+	}
+	if ct := ConstraintName(strings.Repeat("column", 10), names[long]); len(ct) != 63 || !strings.HasSuffix(ct, "_"+hash) {
+		t.Fatal(ct)
+	}
+}
@@ -0,0 +1,17 @@
+package orm
+
+// OutboxTable is the SQL table storing the change records
+// written by the generated CRUD functions.
+const OutboxTable = "outbox"
+
+// OutboxCreateStmt creates the outbox table.
+const OutboxCreateStmt = `
+CREATE TABLE ` + OutboxTable + ` (
+	id bigserial PRIMARY KEY,
+	table_name varchar NOT NULL,
+	operation varchar NOT NULL,
+	key jsonb NOT NULL,
+	before jsonb,
+	after jsonb,
+	created_at timestamp (0) with time zone NOT NULL DEFAULT now()
+);`
@@ -0,0 +1,375 @@
+// Package seed generates a seeder, filling all the tables with
+// random rows built by the functions of the rand mode, which must be
+// generated in the same package.
+package seed
+
+import (
+	"bytes"
+	"fmt"
+	"go/types"
+	"log"
+	"strings"
+	"text/template"
+
+	"github.com/benoitkugler/structgen/loader"
+	"github.com/benoitkugler/structgen/orm"
+	"github.com/benoitkugler/structgen/orm/composites"
+)
+
+var _ loader.Handler = (*handler)(nil)
+
+type handler struct {
+	packageName string
+	withContext bool
+
+	tables     []orm.GoSQLTable
+	uniqueKeys map[string][][]string // Go table name -> SQL columns
+}
+
+// NewHandler returns a handler generating the seeder.
+// `withContext` must match the option used for the sql mode.
+func NewHandler(packageName string, withContext bool) loader.Handler {
+	return &handler{packageName: packageName, withContext: withContext, uniqueKeys: map[string][][]string{}}
+}
+
+func (l *handler) HandleType(typ types.Type) loader.Type {
+	item, isTable := orm.TypeToSQLStruct(typ, nil)
+	if !isTable {
+		return nil
+	}
+	l.tables = append(l.tables, item)
+	// unique indexes declared by tags
+	for _, field := range item.Fields {
+		if index, has, err := field.Index(item.Name); has && err == nil && index.UniqueColumns() != nil { // errors are reported by the creation handler
+			l.uniqueKeys[item.Name] = append(l.uniqueKeys[item.Name], index.UniqueColumns())
+		}
+	}
+	return nil // all the logic is implemented in Footer()
+}
+
+func (l *handler) HandleComment(comment loader.Comment) error {
+	var columns []string
+	switch comment.Tag {
+	case "sql":
+		columns = orm.UniqueConstraintColumns(comment)
+	case "sql_index":
+		index, err := orm.NewIndex(comment, nil)
+		if err != nil { // reported by the creation handler
+			return nil
+		}
+		columns = index.UniqueColumns()
+	}
+	if columns != nil {
+		l.uniqueKeys[comment.TypeName] = append(l.uniqueKeys[comment.TypeName], columns)
+	}
+	return nil
+}
+
+// reference is a foreign key, set to the ID of a previously inserted row.
+// When the key is not nullable, the first row of a table referencing itself
+// references its own ID.
+type reference struct {
+	Field  orm.SQLField
+	Target orm.GoSQLTable
+	Self   bool // the table references itself
+}
+
+// IsSerial returns `true` if the ID of the target is a serial.
+func (r reference) IsSerial() bool {
+	kind := r.Target.IDField().Primary
+	return kind == orm.Serial || kind == orm.BigSerial
+}
+
+// IsNatural returns `true` if the ID of the target is provided by the caller.
+func (r reference) IsNatural() bool { return r.Target.IDField().Primary == orm.Natural }
+
+// IDs returns the name of the Seeded field storing the IDs of the target.
+func (r reference) IDs() string { return idsField(r.Target) }
+
+// NullField returns the field of the sql.NullXXX wrapper of the key,
+// or an empty string if the key is not nullable.
+func (r reference) NullField() string {
+	if !strings.HasPrefix(r.Field.GoTypeName, "sql.Null") {
+		return ""
+	}
+	return strings.TrimPrefix(r.Field.GoTypeName, "sql.Null")
+}
+
+func idsField(table orm.GoSQLTable) string {
+	return strings.ToLower(table.Name[:1]) + table.Name[1:] + "IDs"
+}
+
+// seedTable is a table to fill
+type seedTable struct {
+	orm.GoSQLTable
+	References []reference
+	Uniques    [][]orm.SQLField
+	CtxArg     string // see seeder.CtxArg
+}
+
+// IDs returns the name of the Seeded field storing the IDs of the table.
+func (t seedTable) IDs() string { return idsField(t.GoSQLTable) }
+
+// UniqueKey returns the expression identifying the values of `key` for `item`.
+func (seedTable) UniqueKey(key []orm.SQLField, item string) string {
+	args := make([]string, len(key))
+	for i, field := range key {
+		args[i] = item + "." + field.GoName
+	}
+	return "fmt.Sprint(" + strings.Join(args, `, "|", `) + ")"
+}
+
+func newSeedTable(table orm.GoSQLTable, tables map[string]orm.GoSQLTable, uniqueKeys [][]string) seedTable {
+	out := seedTable{GoSQLTable: table}
+	for _, field := range table.Fields.ForeignKeys() {
+		target, ok := tables[field.ForeignKey()]
+		if !ok || !target.HasID() {
+			log.Printf("warning : foreign key %s.%s does not reference a known table, left random", table.Name, field.GoName)
+			continue
+		}
+		out.References = append(out.References, reference{Field: field, Target: target, Self: target.Name == table.Name})
+	}
+
+	bySQLName := map[string]orm.SQLField{}
+	for _, field := range table.Fields {
+		bySQLName[field.SQLName] = field
+	}
+	seen := map[string]bool{}
+	for _, key := range uniqueKeys {
+		if seen[strings.Join(key, ",")] {
+			continue
+		}
+		seen[strings.Join(key, ",")] = true
+		var fields []orm.SQLField
+		for _, column := range key {
+			field, ok := bySQLName[column]
+			if !ok {
+				fields = nil
+				break
+			}
+			fields = append(fields, field)
+		}
+		if len(fields) == 0 || (len(fields) == 1 && fields[0].Primary.IsGenerated()) {
+			continue
+		}
+		out.Uniques = append(out.Uniques, fields)
+	}
+	return out
+}
+
+// Header returns the package clause and the seeder helpers.
+func (l *handler) Header() string {
+	args := seeder{WithContext: l.withContext}
+	var ctxMethod string
+	if l.withContext {
+		ctxMethod = "Context(ctx, "
+	} else {
+		ctxMethod = "("
+	}
+	return fmt.Sprintf(`
+	package %s
+
+	// Code generated by structgen. DO NOT EDIT.
+
+	// maxSeedAttempts is the number of random items generated
+	// to find one respecting the UNIQUE constraints.
+	const maxSeedAttempts = 100
+
+	// nextSerial returns the value the next insertion in 'table' will use
+	// for its serial 'column', without consuming it.
+	func nextSerial(%[2]stx *sql.Tx, table, column string) (int64, error) {
+		var id int64
+		err := tx.QueryRow%[3]s"SELECT nextval(pg_get_serial_sequence($1, $2))", table, column).Scan(&id)
+		if err != nil {
+			return 0, err
+		}
+		_, err = tx.Exec%[3]s"SELECT setval(pg_get_serial_sequence($1, $2), $3, false)", table, column, id)
+		return id, err
+	}
+
+	// addUnique returns false if one of 'keys' is already in 'seen',
+	// and registers them otherwise.
+	func addUnique(seen []map[string]bool, keys ...string) bool {
+		for i, key := range keys {
+			if seen[i][key] {
+				return false
+			}
+		}
+		for i, key := range keys {
+			seen[i][key] = true
+		}
+		return true
+	}
+	`, l.packageName, args.CtxParam(), ctxMethod)
+}
+
+// Footer returns the seeder, once all the tables are known.
+func (l *handler) Footer() string {
+	byName := make(map[string]orm.GoSQLTable, len(l.tables))
+	for _, table := range l.tables {
+		byName[table.TableName()] = table
+	}
+	args := seeder{WithContext: l.withContext}
+	for _, table := range composites.TopologicalOrder(l.tables) {
+		st := newSeedTable(table, byName, l.uniqueKeys[table.Name])
+		st.CtxArg = args.CtxArg()
+		args.Tables = append(args.Tables, st)
+	}
+	var out bytes.Buffer
+	if err := templateSeeder.Execute(&out, args); err != nil {
+		panic(err)
+	}
+	return out.String()
+}
+
+type seeder struct {
+	WithContext bool
+	Tables      []seedTable
+}
+
+// CtxParam and CtxArg mirror the crud options.
+func (s seeder) CtxParam() string {
+	if s.WithContext {
+		return "ctx context.Context, "
+	}
+	return ""
+}
+
+func (s seeder) CtxArg() string {
+	if s.WithContext {
+		return "ctx, "
+	}
+	return ""
+}
+
+var templateSeeder = template.Must(template.New("").Funcs(orm.FnMap).Parse(`
+{{- define "random" -}}
+rand{{ .Name }}From(r)
+			{{- range .References }}
+			{{- if .NullField }}
+			if ids := out.{{ .IDs }}; len(ids) != 0 {
+				item.{{ .Field.GoName }} = sql.Null{{ .NullField }}{ {{- .NullField }}: ids[r.Intn(len(ids))], Valid: true}
+			} else {
+				item.{{ .Field.GoName }} = sql.Null{{ .NullField }}{}
+			}
+			{{- else if .Self }}
+			if len(out.{{ .IDs }}) == 0 { // the first row references itself
+				{{- if .IsSerial }}
+				id, err := nextSerial({{ $.CtxArg }}tx, "{{ .Target.TableName }}", "{{ .Target.IDField.SQLName }}")
+				if err != nil {
+					return err
+				}
+				item.{{ .Field.GoName }} = {{ .Field.ValueGoType }}(id)
+				{{- else if .IsNatural }}
+				item.{{ .Field.GoName }} = item.{{ .Target.IDField.GoName }}
+				{{- else }}
+				return errors.New("the first row of {{ .Target.TableName }} can't reference itself : {{ .Field.GoName }} must be nullable")
+				{{- end }}
+			} else {
+				item.{{ .Field.GoName }} = out.{{ .IDs }}[r.Intn(len(out.{{ .IDs }}))]
+			}
+			{{- else }}
+			if len(out.{{ .IDs }}) == 0 {
+				return errors.New("no row in {{ .Target.TableName }} to reference")
+			}
+			item.{{ .Field.GoName }} = out.{{ .IDs }}[r.Intn(len(out.{{ .IDs }}))]
+			{{- end }}
+			{{- end }}
+			if s.{{ .Name }} != nil {
+				s.{{ .Name }}(&item)
+			}
+{{- end }}
+// Seeder inserts random rows, built by the rand<Table> functions, in all the tables,
+// following the order of the foreign keys. The foreign keys reference previously
+// inserted rows, and the UNIQUE constraints are respected.
+type Seeder struct {
+	// Counts is the number of rows inserted, by table name.
+	// The tables not listed use DefaultCount, or 10 if it is zero.
+	Counts       map[string]int
+	DefaultCount int
+
+	// Rand is the source of the random values, so that a fixed seed
+	// reproduces the same rows. The global source is used if it is nil.
+	Rand *rand.Rand
+
+	// The following functions, if not nil, are called on each random item,
+	// after its foreign keys are set and before its insertion.
+	// They may override any field.
+	{{ range .Tables }}{{ .Name }} func(item *{{ .Name }})
+	{{ end }}
+}
+
+// Seeded stores the rows inserted by a Seeder.
+type Seeded struct {
+	{{ range .Tables }}{{ .Name }}s {{ .Name }}s
+	{{ end }}
+	{{ range .Tables }}{{ if .HasID }}{{ .IDs }} []{{ .IDField.ValueGoType }} // in insertion order
+	{{ end }}{{ end -}}
+}
+
+func (s Seeder) count(table string) int {
+	if count, has := s.Counts[table]; has {
+		return count
+	}
+	if s.DefaultCount != 0 {
+		return s.DefaultCount
+	}
+	return 10
+}
+
+// Seed inserts the rows, and returns them.
+func (s Seeder) Seed({{ .CtxParam }}tx *sql.Tx) (Seeded, error) {
+	var out Seeded
+	r := s.Rand
+	if r == nil {
+		r = globalRand
+	}
+	{{- range .Tables }}
+	if err := s.seed{{ .Name }}s({{ $.CtxArg }}tx, r, &out); err != nil {
+		return out, fmt.Errorf("seeding {{ .TableName }}: %w", err)
+	}
+	{{- end }}
+	return out, nil
+}
+{{ range .Tables }}
+func (s Seeder) seed{{ .Name }}s({{ $.CtxParam }}tx *sql.Tx, r *rand.Rand, out *Seeded) error {
+	{{- $table := . }}
+	{{- if .HasID }}
+	out.{{ .Name }}s = make({{ .Name }}s)
+	{{- end }}
+	{{- if .Uniques }}
+	seen := []map[string]bool{ {{- range .Uniques }}{}, {{ end -}} }
+	{{- end }}
+	for i := 0; i < s.count("{{ .TableName }}"); i++ {
+		{{- if .Uniques }}
+		var item {{ .Name }}
+		for attempt := 0; ; attempt++ {
+			if attempt == maxSeedAttempts {
+				return fmt.Errorf("no item respecting the UNIQUE constraints found after %d attempts", attempt)
+			}
+			item = {{ template "random" . }}
+			if addUnique(seen {{- range .Uniques }}, {{ $table.UniqueKey . "item" }}{{ end }}) {
+				break
+			}
+		}
+		{{- else }}
+		item := {{ template "random" . }}
+		{{- end }}
+		{{- if .HasID }}
+		item, err := item.Insert({{ $.CtxArg }}tx)
+		if err != nil {
+			return err
+		}
+		out.{{ .Name }}s[item.{{ .IDField.GoName }}] = item
+		out.{{ .IDs }} = append(out.{{ .IDs }}, item.{{ .IDField.GoName }})
+		{{- else }}
+		if err := InsertMany{{ .Name }}s({{ $.CtxArg }}tx, item); err != nil {
+			return err
+		}
+		out.{{ .Name }}s = append(out.{{ .Name }}s, item)
+		{{- end }}
+	}
+	return nil
+}
+{{ end }}
+`))
@@ -0,0 +1,59 @@
+package seed
+
+import (
+	"go/types"
+	"strings"
+	"testing"
+
+	"github.com/benoitkugler/structgen/internal/ormtest"
+	"github.com/benoitkugler/structgen/loader"
+	"github.com/benoitkugler/structgen/orm"
+)
+
+func TestSeeder(t *testing.T) {
+	int64T, stringT := types.Typ[types.Int64], types.Typ[types.String]
+	user := ormtest.NewTable("User", []string{"Id", "Email"}, []types.Type{int64T, stringT},
+		[]string{`json:"id"`, `json:"email" sql_index:"unique"`})
+	post := ormtest.NewTable("Post", []string{"Id", "IdUser", "Title"}, []types.Type{int64T, int64T, stringT},
+		[]string{`json:"id"`, `json:"id_user"`, `json:"title"`})
+
+	h := NewHandler("test", false).(*handler)
+	h.tables = []orm.GoSQLTable{post, user}
+	h.HandleComment(loader.Comment{TypeName: "Post", Tag: "sql", Content: "ADD UNIQUE(id_user, title)"})
+	h.HandleComment(loader.Comment{TypeName: "Post", Tag: "sql", Content: "ADD UNIQUE(id_user, title)"})
+
+	byName := map[string]orm.GoSQLTable{"users": user, "posts": post}
+	table := newSeedTable(post, byName, h.uniqueKeys["Post"])
+	if len(table.References) != 1 || table.References[0].IDs() != "userIDs" || table.References[0].NullField() != "" {
+		t.Fatal(table.References)
+	}
+	if len(table.Uniques) != 1 || table.UniqueKey(table.Uniques[0], "item") != `fmt.Sprint(item.IdUser, "|", item.Title)` {
+		t.Fatal(table.Uniques)
+	}
+
+	code := h.Footer()
+	if strings.Index(code, "s.seedUsers(") > strings.Index(code, "s.seedPosts(") {
+		t.Fatal("users must be inserted before posts")
+	}
+}
+
+func TestSelfReference(t *testing.T) {
+	int64T := types.Typ[types.Int64]
+	category := ormtest.NewTable("Category", []string{"Id", "IdParent"}, []types.Type{int64T, int64T},
+		[]string{`json:"id"`, `json:"id_parent" sql_foreign_key:"category"`})
+
+	h := NewHandler("test", false).(*handler)
+	h.tables = []orm.GoSQLTable{category}
+	table := newSeedTable(category, map[string]orm.GoSQLTable{"categorys": category}, nil)
+	if len(table.References) != 1 || !table.References[0].Self || !table.References[0].IsSerial() {
+		t.Fatal(table.References)
+	}
+
+	code := h.Footer()
+	if !strings.Contains(code, `id, err := nextSerial(tx, "categorys", "id")`) || !strings.Contains(code, "item.IdParent = int64(id)") {
+		t.Fatal(code)
+	}
+	if header := h.Header(); !strings.Contains(header, "func nextSerial(tx *sql.Tx,") || !strings.Contains(header, `tx.QueryRow("SELECT nextval`) {
+		t.Fatal(h.Header())
+	}
+}
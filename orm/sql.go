@@ -2,23 +2,179 @@ package orm
 
 import (
 	"fmt"
+	"go/types"
 	"reflect"
 	"strings"
 
 	"github.com/benoitkugler/structgen/orm/sqltypes"
 )
 
+// PrimaryKind describes how a primary key column is declared.
+type PrimaryKind uint8
+
+const (
+	NotPrimary PrimaryKind = iota
+	Serial                 // 32 bits integer generated by the database
+	BigSerial              // 64 bits integer generated by the database
+	UUID                   // uuid generated by the database with gen_random_uuid()
+	Natural                // provided by the caller (composite keys, foreign keys, text keys)
+)
+
+// IsGenerated returns `true` for keys filled by the database
+// on insertion.
+func (k PrimaryKind) IsGenerated() bool {
+	return k == Serial || k == BigSerial || k == UUID
+}
+
 type SQLField struct {
 	GoName     string
 	SQLName    string
 	Type       sqltypes.SQLType
 	Exported   bool
+	Primary    PrimaryKind
 	goTag      reflect.StructTag // struct field tag
-	GoTypeName string
+	GoTypeName string            // as written in the package defining the struct
+	valueType  string            // GoTypeName, without sql.NullXXX wrapper
 }
 
 func (s SQLField) IsPrimary() bool {
-	return s.GoName == "Id"
+	return s.Primary != NotPrimary
+}
+
+// ValueGoType returns the Go type of the field, with
+// sql.NullXXX wrappers removed.
+func (s SQLField) ValueGoType() string {
+	return s.valueType
+}
+
+// tagOptions are the options supported in the 'sql' tag
+var tagOptions = map[string]bool{
+	"pk":          true,
+	"soft_delete": true,
+	"created_at":  true,
+	"updated_at":  true,
+	"version":     true,
+	"uuid":        true,
+}
+
+// hasTagOption returns `true` if the 'sql' tag of the field
+// contains `option` after the column name, which may be empty,
+// as in `sql:"id,pk"` or `sql:",pk"`.
+func hasTagOption(tag reflect.StructTag, option string) bool {
+	for _, chunk := range strings.Split(tag.Get("sql"), ",")[1:] {
+		if strings.TrimSpace(chunk) == option {
+			return true
+		}
+	}
+	return false
+}
+
+// IsSoftDelete returns `true` for the column tagged with `sql:",soft_delete"`,
+// storing the deletion time of the row. It must be nullable, and is only
+// supported for tables with a single column primary key.
+func (s SQLField) IsSoftDelete() bool { return hasTagOption(s.goTag, "soft_delete") }
+
+// IsCreatedAt returns `true` for the column tagged with `sql:",created_at"`,
+// set to now() on insertion. It defaults to now(), so that it is also
+// set by the bulk insertions.
+func (s SQLField) IsCreatedAt() bool { return hasTagOption(s.goTag, "created_at") }
+
+// IsUpdatedAt returns `true` for the column tagged with `sql:",updated_at"`,
+// set to now() on insertion and update.
+func (s SQLField) IsUpdatedAt() bool { return hasTagOption(s.goTag, "updated_at") }
+
+// IsVersion returns `true` for the column tagged with `sql:",version"`,
+// used for optimistic locking : it is incremented by each update,
+// which fails if the version has changed since the row was read.
+// It must be an integer.
+func (s SQLField) IsVersion() bool { return hasTagOption(s.goTag, "version") }
+
+// isNullable returns `true` if the Go type accepts NULL values,
+// that is for pointers and sql.NullXXX wrappers.
+func (s SQLField) isNullable() bool {
+	return strings.HasPrefix(s.GoTypeName, "*") || s.GoTypeName != s.valueType
+}
+
+// primaryKind returns the kind of a key declared on one column.
+func (s SQLField) primaryKind() PrimaryKind {
+	if s.ForeignKey() != "" { // the value comes from the referenced table
+		return Natural
+	}
+	switch under := s.Type.Go.Underlying().(type) {
+	case *types.Basic:
+		switch under.Kind() {
+		case types.Int64, types.Int, types.Uint64, types.Uint:
+			return BigSerial
+		case types.Int32, types.Int16, types.Int8, types.Uint32, types.Uint16, types.Uint8:
+			return Serial
+		case types.String: // natural text key, unless opted in with `sql:",pk,uuid"`
+			if hasTagOption(s.goTag, "uuid") {
+				return UUID
+			}
+		}
+	case *types.Array: // such as github.com/google/uuid.UUID
+		if basic, ok := under.Elem().(*types.Basic); ok && basic.Kind() == types.Byte && under.Len() == 16 {
+			return UUID
+		}
+	}
+	return Natural
+}
+
+// referenceTypes are the SQL types of the columns
+// referencing a key generated by the database.
+var referenceTypes = map[PrimaryKind]sqltypes.Builtin{
+	Serial:    "integer",
+	BigSerial: "bigint",
+	UUID:      sqltypes.SQLUUID,
+}
+
+// References returns a copy of the field, whose SQL type
+// is the one of the primary key `key` it references.
+func (s SQLField) References(key SQLField) SQLField {
+	if builtin, ok := referenceTypes[key.Primary]; ok {
+		s.Type.Type = builtin
+	} else {
+		s.Type.Type = key.Type.Type
+	}
+	return s
+}
+
+// setPrimaryKey resolves the primary key of a table:
+// fields tagged with `sql:",pk"` are used if any, and the 'Id' field
+// is used as a fallback.
+// A single integer key is generated by the database, unless it is also a foreign key.
+// A single string key is provided by the caller, unless tagged with `sql:",pk,uuid"`.
+//
+// The 'Id' field is a bigserial for 64 bits integers (int and int64), and a serial otherwise.
+// Databases created when every 'Id' was a serial are migrated with
+//	ALTER TABLE <table> ALTER COLUMN id TYPE bigint;
+//	ALTER SEQUENCE <table>_id_seq AS bigint;
+// and the foreign keys referencing the table with
+//	ALTER TABLE <table> ALTER COLUMN id_<referenced> TYPE bigint;
+func setPrimaryKey(fs []SQLField) {
+	var tagged []int
+	for i, f := range fs {
+		if hasTagOption(f.goTag, "pk") {
+			tagged = append(tagged, i)
+		}
+	}
+	switch len(tagged) {
+	case 0:
+		for i, f := range fs {
+			if f.GoName == "Id" {
+				fs[i].Primary = Serial
+				if f.primaryKind() == BigSerial {
+					fs[i].Primary = BigSerial
+				}
+			}
+		}
+	case 1:
+		fs[tagged[0]].Primary = fs[tagged[0]].primaryKind()
+	default:
+		for _, i := range tagged {
+			fs[i].Primary = Natural
+		}
+	}
 }
 
 // ForeignKey returns the name to the table this field references
@@ -27,32 +183,89 @@ func (s SQLField) ForeignKey() string {
 	if sqlTableNoS := s.goTag.Get("sql_foreign_key"); sqlTableNoS != "" {
 		return sqlTableNoS + "s"
 	}
-	if !s.IsPrimary() && strings.HasPrefix(s.GoName, "Id") {
+	if !s.Primary.IsGenerated() && strings.HasPrefix(s.GoName, "Id") && s.GoName != "Id" {
 		goTableName := strings.TrimPrefix(s.GoName, "Id")
 		return tableName(goTableName)
 	}
 	return ""
 }
 
+// CreateStmt returns the column declaration. Composite primary keys
+// are not included and must be declared at the table level.
 func (s SQLField) CreateStmt() string {
 	var typeDecl string
-	if s.IsPrimary() {
+	switch s.Primary {
+	case Serial:
 		typeDecl = "serial PRIMARY KEY"
-	} else {
+	case BigSerial:
+		typeDecl = "bigserial PRIMARY KEY"
+	case UUID:
+		typeDecl = "uuid PRIMARY KEY DEFAULT gen_random_uuid()"
+	default:
 		typeDecl = s.Type.Declaration(s.SQLName)
+		if s.IsCreatedAt() || s.IsUpdatedAt() {
+			typeDecl += " DEFAULT now()"
+		}
 	}
 	// we defer foreign contraints in separate declaration
 	return fmt.Sprintf("%s %s", s.SQLName, typeDecl)
 }
 
-func (s SQLField) onDeleteConstraint() string {
+// NativeEnum returns the PostgreSQL enum type storing the field,
+// named after the Go type (see sqltypes.NewNativeEnum),
+// or false if the field is not a string enum.
+func (s SQLField) NativeEnum() (sqltypes.NativeEnum, bool) {
+	enum, ok := s.Type.Type.(sqltypes.Enum)
+	if basic, isBasic := s.Type.Go.Underlying().(*types.Basic); !ok || !isBasic || basic.Info()&types.IsString == 0 {
+		return sqltypes.NativeEnum{}, false
+	}
+	return sqltypes.NewNativeEnum(toSnakeCase(enum.Name), enum.Type), true
+}
+
+// OnDelete returns the ON DELETE action of the foreign key,
+// given by the `sql_on_delete` tag, or an empty string.
+func (s SQLField) OnDelete() string {
 	return s.goTag.Get("sql_on_delete")
 }
 
+// ValueArg returns the Go expression sending the field of `item`
+// as query argument.
+func (s SQLField) ValueArg(item string) string {
+	return s.Type.Codec.Value(item + "." + s.GoName)
+}
+
+// ScanArg returns the Go expression scanning a column
+// into the field of `item`.
+func (s SQLField) ScanArg(item string) string {
+	return s.Type.Codec.Scan(item + "." + s.GoName)
+}
+
 type fields []SQLField
 
-// excludes primary key
+// excludes primary key generated by the database
 func (fs fields) NoId() fields {
+	var out fields
+	for _, f := range fs {
+		if !f.Primary.IsGenerated() {
+			out = append(out, f)
+		}
+	}
+	return out
+}
+
+// select the primary key columns
+func (fs fields) Primary() fields {
+	var out fields
+	for _, f := range fs {
+		if f.IsPrimary() {
+			out = append(out, f)
+		}
+	}
+	return out
+}
+
+// excludes all primary key columns
+func (fs fields) NotPrimary() fields {
 	var out fields
 	for _, f := range fs {
 		if !f.IsPrimary() {
@@ -66,7 +279,7 @@ func (fs fields) NoId() fields {
 func (fs fields) ForeignKeys() fields {
 	var out fields
 	for _, f := range fs {
-		if !f.IsPrimary() && f.ForeignKey() != "" {
+		if f.ForeignKey() != "" {
 			// we found a foreign key
 			out = append(out, f)
 		}
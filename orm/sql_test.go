@@ -0,0 +1,198 @@
+package orm
+
+import (
+	"go/types"
+	"strings"
+	"testing"
+
+	"github.com/benoitkugler/structgen/enums"
+)
+
+// newStruct builds a struct type from field names, types and tags
+func newStruct(names []string, typs []types.Type, tags []string) *types.Struct {
+	pkg := types.NewPackage("test", "test")
+	fields := make([]*types.Var, len(names))
+	for i, name := range names {
+		fields[i] = types.NewField(0, pkg, name, typs[i], false)
+	}
+	return types.NewStruct(fields, tags)
+}
+
+func TestPrimaryKey(t *testing.T) {
+	int64T, stringT := types.Typ[types.Int64], types.Typ[types.String]
+
+	legacy := NewGoSQLTable("User", newStruct([]string{"Id", "Name"}, []types.Type{int64T, stringT}, nil), nil)
+	if !legacy.HasID() || legacy.IDField().Primary != BigSerial {
+		t.Fatal(legacy.Fields)
+	}
+	if legacy.IDField().CreateStmt() != "Id bigserial PRIMARY KEY" {
+		t.Fatal(legacy.IDField().CreateStmt())
+	}
+	small := NewGoSQLTable("Tag", newStruct([]string{"Id"}, []types.Type{types.Typ[types.Int32]}, nil), nil)
+	if small.IDField().CreateStmt() != "Id serial PRIMARY KEY" {
+		t.Fatal(small.IDField().CreateStmt())
+	}
+
+	big := NewGoSQLTable("Event", newStruct([]string{"Key", "Id"}, []types.Type{int64T, int64T}, []string{`sql:",pk"`, ""}), nil)
+	if !big.HasID() || big.IDField().GoName != "Key" || big.IDField().SQLName != "Key" || big.IDField().Primary != BigSerial {
+		t.Fatal(big.Fields)
+	}
+	if len(big.Fields.NoId()) != 1 || big.Fields.ForeignKeys() != nil {
+		t.Fatal(big.Fields)
+	}
+
+	natural := NewGoSQLTable("Country", newStruct([]string{"Code"}, []types.Type{stringT}, []string{`json:"code" sql:",pk"`}), nil)
+	if !natural.HasID() || natural.IDField().Primary != Natural {
+		t.Fatal(natural.Fields)
+	}
+
+	uuid := NewGoSQLTable("Document", newStruct([]string{"Ref"}, []types.Type{stringT}, []string{`json:"ref" sql:",pk,uuid"`}), nil)
+	if !uuid.HasID() || uuid.IDField().SQLName != "ref" || uuid.IDField().Primary != UUID {
+		t.Fatal(uuid.Fields)
+	}
+	if uuid.IDField().CreateStmt() != "ref uuid PRIMARY KEY DEFAULT gen_random_uuid()" {
+		t.Fatal(uuid.IDField().CreateStmt())
+	}
+
+	composite := NewGoSQLTable("Share", newStruct([]string{"IdDocument", "IdGroup", "Level"},
+		[]types.Type{stringT, int64T, int64T}, []string{`sql:",pk"`, `sql:",pk"`, ""}), nil)
+	if composite.HasID() || !composite.HasCompositeKey() {
+		t.Fatal(composite.Fields)
+	}
+	if len(composite.Fields.NoId()) != 3 || len(composite.Fields.NotPrimary()) != 1 || len(composite.Fields.ForeignKeys()) != 2 {
+		t.Fatal(composite.Fields)
+	}
+
+	extension := NewGoSQLTable("Profile", newStruct([]string{"IdUser"}, []types.Type{int64T}, []string{`sql:",pk"`}), nil)
+	if !extension.HasID() || extension.IDField().Primary != Natural || len(extension.Fields.ForeignKeys()) != 1 {
+		t.Fatal(extension.Fields)
+	}
+}
+
+func TestNativeEnum(t *testing.T) {
+	pkg := types.NewPackage("test", "test")
+	newEnum := func(name string, basic types.BasicKind) *types.Named {
+		return types.NewNamed(types.NewTypeName(0, pkg, name, nil), types.Typ[basic], nil)
+	}
+	et := enums.EnumTable{
+		"UserRole": {Name: "UserRole", Values: []enums.EnumValue{{VarName: "Admin", Value: `"admin"`}, {VarName: "Basic", Value: `"basic"`}}},
+		"Level":    {Name: "Level", IsInt: true, Values: []enums.EnumValue{{VarName: "Low", Value: "0"}}},
+		"Text":     {Name: "Text", Values: []enums.EnumValue{{VarName: "Quote", Value: `"it's \"quoted\""`}}},
+	}
+	table := NewGoSQLTable("User", newStruct(
+		[]string{"Id", "Role", "Level", "Text"},
+		[]types.Type{types.Typ[types.Int64], newEnum("UserRole", types.String), newEnum("Level", types.Int), newEnum("Text", types.String)},
+		[]string{`json:"id"`, `json:"role"`, `json:"level"`, `json:"text"`},
+	), et)
+
+	enum, ok := table.Fields[1].NativeEnum()
+	if !ok || enum.SQLName != "user_role" {
+		t.Fatal(enum)
+	}
+	stmt := enum.CreateStmt()
+	if !strings.Contains(stmt, "CREATE TYPE user_role AS ENUM ('admin', 'basic');") ||
+		!strings.Contains(stmt, "ALTER TYPE user_role ADD VALUE IF NOT EXISTS 'basic';") {
+		t.Fatal(stmt)
+	}
+	if _, ok := table.Fields[2].NativeEnum(); ok {
+		t.Fatal("int enums are not native")
+	}
+	if text, _ := table.Fields[3].NativeEnum(); text.SQLName != "text_enum" || !strings.Contains(text.CreateStmt(), `AS ENUM ('it''s "quoted"');`) {
+		t.Fatal(text.CreateStmt())
+	}
+	field := table.Fields[1]
+	field.Type.Type = enum
+	if field.CreateStmt() != "role user_role  NOT NULL" {
+		t.Fatal(field.CreateStmt())
+	}
+}
+
+func TestColumnTypes(t *testing.T) {
+	named := func(pkgPath, name string, underlying types.Type) *types.Named {
+		pkg := types.NewPackage(pkgPath, pkgPath[strings.LastIndexByte(pkgPath, '/')+1:])
+		return types.NewNamed(types.NewTypeName(0, pkg, name, nil), underlying, nil)
+	}
+	table := NewGoSQLTable("Event", newStruct(
+		[]string{"Id", "Count", "Small", "Ratio", "Name", "Code", "Body", "Price", "Ref", "Client", "Delay", "Forced", "Other"},
+		[]types.Type{
+			types.Typ[types.Int64], types.Typ[types.Int32], types.Typ[types.Int16], types.Typ[types.Float64],
+			types.Typ[types.String], types.Typ[types.String], types.Typ[types.String],
+			named("github.com/shopspring/decimal", "Decimal", &types.Struct{}),
+			named("github.com/google/uuid", "UUID", types.NewArray(types.Typ[types.Byte], 16)),
+			named("net", "IP", types.NewSlice(types.Typ[types.Byte])),
+			named("time", "Duration", types.Typ[types.Int64]),
+			types.Typ[types.Int64],
+			named("example.com/ids", "UUID", types.Typ[types.String]), // not a known UUID
+		},
+		[]string{"", "", "", "", "", `sql_len:"12"`, `sql_type:"text"`, `sql_len:"10,2"`, "", "", "", `sql_type:"numeric"`, ""},
+	), nil)
+
+	expected := []string{
+		"Id bigserial PRIMARY KEY",
+		"Count integer  NOT NULL",
+		"Small smallint  NOT NULL",
+		"Ratio double precision  NOT NULL",
+		"Name varchar  NOT NULL",
+		"Code varchar(12)  NOT NULL",
+		"Body text  NOT NULL",
+		"Price numeric(10,2)  NOT NULL",
+		"Ref uuid  NOT NULL",
+		"Client inet ",
+		"Delay interval  NOT NULL",
+		"Forced numeric  NOT NULL",
+		"Other varchar  NOT NULL",
+	}
+	for i, field := range table.Fields {
+		if got := field.CreateStmt(); got != expected[i] {
+			t.Errorf("expected %q, got %q", expected[i], got)
+		}
+	}
+
+	if delay := table.Fields[10]; delay.ScanArg("s") != "durationScanner{&s.Delay}" || delay.ValueArg("item") != "durationValue(item.Delay)" {
+		t.Fatal(delay.ScanArg("s"), delay.ValueArg("item"))
+	}
+	if name := table.Fields[4]; name.ScanArg("s") != "&s.Name" || name.ValueArg("item") != "item.Name" {
+		t.Fatal(name.ScanArg("s"), name.ValueArg("item"))
+	}
+	if helpers := CodecHelpers([]GoSQLTable{table}); !strings.Contains(helpers, "type durationScanner") || !strings.Contains(helpers, "type ipValue") {
+		t.Fatal(helpers)
+	}
+}
+
+func TestAuditOptions(t *testing.T) {
+	timeT := types.NewNamed(types.NewTypeName(0, types.NewPackage("time", "time"), "Time", nil), types.NewStruct(nil, nil), nil)
+	table := NewGoSQLTable("Post", newStruct([]string{"Id", "Created", "Deleted"},
+		[]types.Type{types.Typ[types.Int64], timeT, types.NewPointer(timeT)},
+		[]string{"", `json:"created" sql:",created_at"`, `json:"deleted" sql:",soft_delete"`}), nil)
+	if stmt := table.Fields[1].CreateStmt(); !strings.HasSuffix(stmt, " DEFAULT now()") {
+		t.Fatal(stmt)
+	}
+	if table.SoftDeleteColumn() != "deleted" {
+		t.Fatal(table.Fields)
+	}
+
+	// the column name is not an option
+	legacy := NewGoSQLTable("Post", newStruct([]string{"Id", "Version"},
+		[]types.Type{types.Typ[types.Int64], types.Typ[types.Int64]}, []string{"", `sql:"version"`}), nil)
+	if legacy.Fields[1].IsVersion() || legacy.Fields[1].SQLName != "version" {
+		t.Fatal(legacy.Fields[1])
+	}
+
+	for _, test := range []struct {
+		names []string
+		typs  []types.Type
+		tags  []string
+	}{
+		{[]string{"Id", "Deleted"}, []types.Type{types.Typ[types.Int64], timeT}, []string{"", `sql:",soft_delete"`}},
+		{[]string{"IdUser", "Deleted"}, []types.Type{types.Typ[types.Int64], types.NewPointer(timeT)}, []string{"", `sql:",soft_delete"`}},
+	} {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Fatalf("expected panic for %v", test.tags)
+				}
+			}()
+			NewGoSQLTable("Post", newStruct(test.names, test.typs, test.tags), nil)
+		}()
+	}
+}
@@ -0,0 +1,146 @@
+package sqltypes
+
+import "fmt"
+
+// Codec identifies the conversion required by Go types
+// which database/sql can't send nor read in their SQL type.
+type Codec uint8
+
+const (
+	NoCodec       Codec = iota
+	DurationCodec       // time.Duration as interval
+	IPCodec             // net.IP as inet
+)
+
+// ValueType returns the generated Go type converting values
+// to a type accepted by database/sql, or an empty string.
+func (c Codec) ValueType() string {
+	switch c {
+	case DurationCodec:
+		return "durationValue"
+	case IPCodec:
+		return "ipValue"
+	default:
+		return ""
+	}
+}
+
+// Value returns the Go expression converting `expr`
+// to a value accepted by database/sql.
+func (c Codec) Value(expr string) string {
+	if c == NoCodec {
+		return expr
+	}
+	return fmt.Sprintf("%s(%s)", c.ValueType(), expr)
+}
+
+// Scan returns the Go expression used as Scan destination
+// for the addressable `expr`.
+func (c Codec) Scan(expr string) string {
+	switch c {
+	case DurationCodec:
+		return fmt.Sprintf("durationScanner{&%s}", expr)
+	case IPCodec:
+		return fmt.Sprintf("ipScanner{&%s}", expr)
+	default:
+		return "&" + expr
+	}
+}
+
+// Helpers returns the Go declarations used by `Value` and `Scan`.
+func (c Codec) Helpers() string {
+	switch c {
+	case DurationCodec:
+		return durationHelpers
+	case IPCodec:
+		return ipHelpers
+	default:
+		return ""
+	}
+}
+
+const durationHelpers = `
+// durationValue stores a time.Duration in an interval column,
+// with a microsecond precision.
+type durationValue time.Duration
+
+func (d durationValue) Value() (driver.Value, error) {
+	return fmt.Sprintf("%d microseconds", time.Duration(d).Microseconds()), nil
+}
+
+// durationScanner reads an interval column, such as
+// '1 day 02:03:04.5', into a time.Duration.
+// Months and years are not supported.
+type durationScanner struct{ d *time.Duration }
+
+func (s durationScanner) Scan(src interface{}) error {
+	var text string
+	switch src := src.(type) {
+	case []byte:
+		text = string(src)
+	case string:
+		text = src
+	default:
+		return fmt.Errorf("unsupported interval %v", src)
+	}
+	var out time.Duration
+	fields := strings.Fields(text)
+	for i := 0; i < len(fields); i++ {
+		if hms := strings.Split(fields[i], ":"); len(hms) == 3 {
+			// the sign, if any, applies to every unit
+			d, err := time.ParseDuration(hms[0] + "h" + hms[1] + "m" + hms[2] + "s")
+			if err != nil {
+				return fmt.Errorf("invalid interval %s: %s", text, err)
+			}
+			out += d
+		} else if i+1 < len(fields) && strings.HasPrefix(fields[i+1], "day") {
+			days, err := strconv.Atoi(fields[i])
+			if err != nil {
+				return fmt.Errorf("invalid interval %s: %s", text, err)
+			}
+			out += time.Duration(days) * 24 * time.Hour
+			i++
+		} else {
+			return fmt.Errorf("unsupported interval %s", text)
+		}
+	}
+	*s.d = out
+	return nil
+}
+`
+
+const ipHelpers = `
+// ipValue stores a net.IP in an inet column.
+type ipValue net.IP
+
+func (ip ipValue) Value() (driver.Value, error) {
+	if ip == nil {
+		return nil, nil
+	}
+	return net.IP(ip).String(), nil
+}
+
+// ipScanner reads an inet column into a net.IP.
+type ipScanner struct{ ip *net.IP }
+
+func (s ipScanner) Scan(src interface{}) error {
+	var text string
+	switch src := src.(type) {
+	case nil:
+		*s.ip = nil
+		return nil
+	case []byte:
+		text = string(src)
+	case string:
+		text = src
+	default:
+		return fmt.Errorf("unsupported inet %v", src)
+	}
+	ip := net.ParseIP(strings.Split(text, "/")[0]) // ignore an eventual netmask
+	if ip == nil {
+		return fmt.Errorf("invalid inet %s", text)
+	}
+	*s.ip = ip
+	return nil
+}
+`
@@ -1,8 +1,10 @@
 package sqltypes
 
 import (
+	"fmt"
 	"go/types"
 	"log"
+	"reflect"
 
 	"github.com/benoitkugler/structgen/enums"
 	"github.com/benoitkugler/structgen/orm/jsonsql"
@@ -11,34 +13,105 @@ import (
 
 const JSONB Builtin = "jsonb"
 
+// sqlBasicTypes uses the smallest SQL integer holding every value
+// of the Go type (uint64 values above 2^63 are not supported).
 var sqlBasicTypes = map[types.BasicKind]Builtin{
 	types.Bool:    "boolean",
-	types.Int:     "integer",
-	types.Int8:    "integer",
-	types.Int16:   "integer",
+	types.Int:     "bigint",
+	types.Int8:    "smallint",
+	types.Int16:   "smallint",
 	types.Int32:   "integer",
-	types.Int64:   "integer",
-	types.Uint:    "integer",
-	types.Uint8:   "integer",
+	types.Int64:   "bigint",
+	types.Uint:    "bigint",
+	types.Uint8:   "smallint",
 	types.Uint16:  "integer",
-	types.Uint32:  "integer",
-	types.Uint64:  "integer",
-	types.Uintptr: "integer",
+	types.Uint32:  "bigint",
+	types.Uint64:  "bigint",
+	types.Uintptr: "bigint",
 	types.Float32: "real",
-	types.Float64: "real",
-	types.String:  "varchar",
+	types.Float64: "double precision",
+	types.String:  SQLVarchar,
 }
 
 func newBuiltin(typ *types.Basic) Builtin {
 	kind := typ.Kind()
 	sqlType, in := sqlBasicTypes[kind]
 	if !in {
-		log.Printf("warning : unknow basic type %s, jsonb used", typ)
+		log.Printf("warning : unknow basic type %s, jsonb used (use a sql_type tag to choose the SQL type)", typ)
 		sqlType = JSONB
 	}
 	return sqlType
 }
 
+func isNamed(typ *types.Named, pkgPath, name string) bool {
+	obj := typ.Obj()
+	return obj.Pkg() != nil && obj.Pkg().Path() == pkgPath && obj.Name() == name
+}
+
+// uuidPackages and decimalPackages are the packages whose UUID
+// and Decimal types are supported, in addition to the types implementing
+// sql.Scanner and driver.Valuer.
+var (
+	uuidPackages = map[string]bool{
+		"github.com/google/uuid":         true,
+		"github.com/gofrs/uuid":          true,
+		"github.com/satori/go.uuid":      true,
+		"github.com/jackc/pgtype":        true,
+		"github.com/jackc/pgx/v5/pgtype": true,
+	}
+	decimalPackages = map[string]bool{
+		"github.com/shopspring/decimal":    true,
+		"github.com/ericlagergren/decimal": true,
+		"github.com/cockroachdb/apd":       true,
+		"github.com/cockroachdb/apd/v2":    true,
+		"github.com/cockroachdb/apd/v3":    true,
+	}
+)
+
+// isValuer returns `true` if `typ` implements driver.Valuer
+// and sql.Scanner (on its pointer).
+func isValuer(typ *types.Named) bool {
+	hasMethod := func(name string, params, results int) bool {
+		sel := types.NewMethodSet(types.NewPointer(typ)).Lookup(typ.Obj().Pkg(), name)
+		if sel == nil {
+			return false
+		}
+		sig := sel.Type().(*types.Signature)
+		return sig.Params().Len() == params && sig.Results().Len() == results
+	}
+	return hasMethod("Value", 0, 2) && hasMethod("Scan", 1, 1)
+}
+
+// isSpecial returns `true` if `typ` is named `name`, and
+// is either defined in one of `packages` or supported by database/sql.
+func isSpecial(typ *types.Named, name string, packages map[string]bool) bool {
+	obj := typ.Obj()
+	if obj.Name() != name || obj.Pkg() == nil {
+		return false
+	}
+	return packages[obj.Pkg().Path()] || isValuer(typ)
+}
+
+// newSpecialType handles the named types with a dedicated SQL type,
+// or returns false.
+func newSpecialType(typ *types.Named) (SQLType, bool) {
+	switch {
+	case typ.Obj().Name() == "Date":
+		return SQLType{Type: SQLDate}, true
+	case utils.IsUnderlyingTime(typ):
+		return SQLType{Type: SQLTime, IsNullable: true}, true
+	case isNamed(typ, "time", "Duration"):
+		return SQLType{Type: SQLInterval, Codec: DurationCodec}, true
+	case isNamed(typ, "net", "IP"):
+		return SQLType{Type: SQLInet, IsNullable: true, Codec: IPCodec}, true
+	case isSpecial(typ, "UUID", uuidPackages): // such as github.com/google/uuid.UUID
+		return SQLType{Type: SQLUUID}, true
+	case isSpecial(typ, "Decimal", decimalPackages): // such as github.com/shopspring/decimal.Decimal
+		return SQLType{Type: SQLNumeric}, true
+	}
+	return SQLType{}, false
+}
+
 type arrayLike interface {
 	Elem() types.Type
 }
@@ -52,7 +125,7 @@ func newTypeFromArray(typ arrayLike, length int64) sqlType {
 		sqlElemType := newBuiltin(elemTyp)
 		return Array{Element: sqlElemType, length: length}
 	default:
-		log.Printf("unknow array element type %s, jsonb used for the whole array", elem)
+		log.Printf("unknow array element type %s, jsonb used for the whole array (use a sql_type tag to choose the SQL type)", elem)
 		return JSONB
 	}
 }
@@ -77,6 +150,17 @@ func isNullable(typ *types.Named) types.Type {
 	return nil
 }
 
+// ValueType returns the type wrapped by a sql.NullXXX struct,
+// or `typ` itself.
+func ValueType(typ types.Type) types.Type {
+	if named, ok := typ.(*types.Named); ok {
+		if inner := isNullable(named); inner != nil {
+			return inner
+		}
+	}
+	return typ
+}
+
 // NewSQLType returns the equivalent SQL type
 // Special case for serial (ID) must be handled by the caller
 // An enum table is needed to detect the types which should be an enum.
@@ -102,11 +186,8 @@ func NewSQLType(typ types.Type, enums enums.EnumTable) SQLType {
 			out = SQLType{Go: typ, Type: newTypeFromArray(typ, -1), IsNullable: true}
 		}
 	case *types.Named:
-		if typ.Obj().Name() == "Date" {
-			// special case for Date type
-			out = SQLType{Go: typ, Type: SQLDate, IsNullable: false}
-		} else if utils.IsUnderlyingTime(typ) {
-			out = SQLType{Go: typ, Type: SQLTime, IsNullable: true}
+		if special, ok := newSpecialType(typ); ok {
+			out = special
 		} else if nullableType := isNullable(typ); nullableType != nil {
 			out = NewSQLType(nullableType, enums) // convert associated type
 			out.IsNullable = true                 // mark as nullable
@@ -130,3 +211,26 @@ func NewSQLType(typ types.Type, enums enums.EnumTable) SQLType {
 	}
 	return out
 }
+
+// WithTag applies the `sql_type` and `sql_len` tags of a struct field.
+// `sql_type` forces the SQL type of the column, such as sql_type:"text".
+// `sql_len` gives the length of a varchar, or the precision
+// and scale of a numeric, such as sql_len:"10,2".
+func (s SQLType) WithTag(tag reflect.StructTag) SQLType {
+	if forced := tag.Get("sql_type"); forced != "" {
+		s.Type = Builtin(forced)
+		if s.Type != JSONB { // the validation function requires jsonb
+			s.JSON = nil
+		}
+		s.Codec = NoCodec
+	}
+	if length := tag.Get("sql_len"); length != "" {
+		switch s.Type {
+		case SQLVarchar, SQLNumeric:
+			s.Type = Builtin(fmt.Sprintf("%s(%s)", s.Type, length))
+		default:
+			log.Printf("warning : sql_len is only supported for varchar and numeric, ignored for %s", s.Type.string())
+		}
+	}
+	return s
+}
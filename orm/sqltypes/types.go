@@ -4,6 +4,7 @@ package sqltypes
 import (
 	"fmt"
 	"go/types"
+	"strings"
 
 	"github.com/benoitkugler/structgen/enums"
 	"github.com/benoitkugler/structgen/orm/jsonsql"
@@ -15,6 +16,7 @@ type SQLType struct {
 	Type       sqlType
 	JSON       jsonsql.TypeJSON // might be null
 	GoName     string
+	Codec      Codec // conversion needed by database/sql, if any
 }
 
 func (s SQLType) Declaration(field string) string {
@@ -39,8 +41,13 @@ type sqlType interface {
 type Builtin string
 
 const (
-	SQLDate = Builtin("date")
-	SQLTime = Builtin("timestamp (0) with time zone")
+	SQLDate     = Builtin("date")
+	SQLTime     = Builtin("timestamp (0) with time zone")
+	SQLVarchar  = Builtin("varchar")
+	SQLNumeric  = Builtin("numeric")
+	SQLUUID     = Builtin("uuid")
+	SQLInet     = Builtin("inet")
+	SQLInterval = Builtin("interval")
 )
 
 func (Builtin) Constraint(string) string { return "" }
@@ -56,6 +63,62 @@ func (e Enum) Constraint(field string) string {
 }
 func (e Enum) string() string { return e.underlying.string() }
 
+// NativeEnum is a string enum stored with a PostgreSQL enum type,
+// named SQLName.
+type NativeEnum struct {
+	SQLName string
+	enums.Type
+}
+
+// builtinTypes are the PostgreSQL builtin type names, which
+// would hide an enum type with the same name.
+var builtinTypes = map[string]bool{
+	"bigint": true, "bigserial": true, "bit": true, "bool": true, "boolean": true,
+	"box": true, "bytea": true, "char": true, "character": true, "cidr": true,
+	"circle": true, "date": true, "decimal": true, "float4": true, "float8": true,
+	"inet": true, "int": true, "int2": true, "int4": true, "int8": true,
+	"integer": true, "interval": true, "json": true, "jsonb": true, "line": true,
+	"lseg": true, "macaddr": true, "macaddr8": true, "money": true, "name": true,
+	"numeric": true, "oid": true, "path": true, "point": true, "polygon": true,
+	"real": true, "record": true, "serial": true, "serial2": true, "serial4": true,
+	"serial8": true, "smallint": true, "smallserial": true, "text": true, "time": true,
+	"timestamp": true, "timestamptz": true, "timetz": true, "tsquery": true, "tsvector": true,
+	"uuid": true, "varbit": true, "varchar": true, "void": true, "xml": true,
+}
+
+// NewNativeEnum returns the enum type named `name`, or `name_enum`
+// if `name` is a builtin type.
+func NewNativeEnum(name string, typ enums.Type) NativeEnum {
+	if builtinTypes[name] {
+		name += "_enum"
+	}
+	return NativeEnum{SQLName: name, Type: typ}
+}
+
+func (NativeEnum) Constraint(string) string { return "" }
+func (e NativeEnum) string() string         { return e.SQLName }
+
+// CreateStmt returns the statements creating the type, and adding
+// the values missing in an existing type, so that they may be run
+// again when values are added to the enum. New values are appended.
+func (e NativeEnum) CreateStmt() string {
+	values := make([]string, len(e.Values))
+	for i, val := range e.Values {
+		values[i] = val.SQLLiteral()
+	}
+	out := fmt.Sprintf(`
+DO $$
+BEGIN
+	CREATE TYPE %s AS ENUM (%s);
+EXCEPTION
+	WHEN duplicate_object THEN NULL;
+END $$;`, e.SQLName, strings.Join(values, ", "))
+	for _, value := range values {
+		out += fmt.Sprintf("\nALTER TYPE %s ADD VALUE IF NOT EXISTS %s;", e.SQLName, value)
+	}
+	return out
+}
+
 // Array is a one-dimensionnal SQL array
 type Array struct {
 	Element Builtin
@@ -1,8 +1,11 @@
 package orm
 
 import (
+	"fmt"
 	"go/types"
+	"log"
 	"reflect"
+	"strings"
 
 	"github.com/benoitkugler/structgen/enums"
 	"github.com/benoitkugler/structgen/orm/sqltypes"
@@ -30,20 +33,57 @@ type GoSQLTable struct {
 func NewGoSQLTable(name string, type_ *types.Struct, enums enums.EnumTable) GoSQLTable {
 	args := GoSQLTable{Name: name}
 	args.Fields = extractStructFields(type_, enums)
+	setPrimaryKey(args.Fields)
+	args.checkSoftDelete()
 	return args
 }
 
+// checkSoftDelete panics if the soft delete column is not supported :
+// the links and composite keys tables are always hard deleted.
+func (t GoSQLTable) checkSoftDelete() {
+	for _, f := range t.Fields {
+		if !f.IsSoftDelete() {
+			continue
+		}
+		if !t.HasID() {
+			panic(fmt.Sprintf("soft delete column %s.%s requires a table with a single column primary key", t.Name, f.GoName))
+		}
+		if !f.isNullable() {
+			panic(fmt.Sprintf("soft delete column %s.%s must be nullable, such as sql.NullTime", t.Name, f.GoName))
+		}
+	}
+}
+
 func (t GoSQLTable) QualifiedGoName(package_ string) string {
 	return package_ + "." + t.Name
 }
 
+// HasID returns `true` if the table has a single column primary key.
 func (t GoSQLTable) HasID() bool {
+	return len(t.Fields.Primary()) == 1
+}
+
+// HasCompositeKey returns `true` if the primary key
+// is made of several columns.
+func (t GoSQLTable) HasCompositeKey() bool {
+	return len(t.Fields.Primary()) > 1
+}
+
+// IDField returns the primary key column.
+// It should only be used when `HasID` is true.
+func (t GoSQLTable) IDField() SQLField {
+	return t.Fields.Primary()[0]
+}
+
+// SoftDeleteColumn returns the SQL name of the column
+// marking a row as deleted, or an empty string.
+func (t GoSQLTable) SoftDeleteColumn() string {
 	for _, f := range t.Fields {
-		if f.IsPrimary() {
-			return true
+		if f.IsSoftDelete() {
+			return f.SQLName
 		}
 	}
-	return false
+	return ""
 }
 
 func (m GoSQLTable) Id() string {
@@ -54,19 +94,44 @@ func tableName(goName string) string {
 	return toSnakeCase(goName) + "s"
 }
 
+// TableName returns the sql table name for
+// the Go struct `goName`.
+func TableName(goName string) string { return tableName(goName) }
+
 // TableName returns the sql table name
 func (m GoSQLTable) TableName() string {
 	return tableName(m.Name)
 }
 
+// ColumnName returns the SQL column of the struct field with tag `tag` :
+// the name given by the 'sql' tag, or by the 'json' tag, or the Go name.
+// It returns an empty string for ignored fields.
+func ColumnName(field *types.Var, tag string) string {
+	name, _ := utils.GetFieldName(field, tag, "sql")
+	if name == "" { // field ignored
+		return ""
+	}
+	if chunks := strings.Split(reflect.StructTag(tag).Get("sql"), ","); chunks[0] == "" && len(chunks) > 1 {
+		// sql:",pk" only gives options, not a column name
+		name, _ = utils.GetFieldName(field, tag, "json")
+	}
+	return name
+}
+
 func extractStructFields(type_ *types.Struct, enums enums.EnumTable) []SQLField {
 	var out []SQLField
 	for i := 0; i < type_.NumFields(); i++ {
 		field := type_.Field(i)
-		sqlFieldName, exported := utils.GetFieldName(field, type_.Tag(i), "sql")
+		sqlFieldName, exported := ColumnName(field, type_.Tag(i)), field.Exported()
 		if sqlFieldName == "" { // field ignored
 			continue
 		}
+		chunks := strings.Split(reflect.StructTag(type_.Tag(i)).Get("sql"), ",")
+		for _, option := range chunks[1:] {
+			if option = strings.TrimSpace(option); !tagOptions[option] {
+				log.Printf("warning : unknown sql tag option %q for field %s, ignored", option, field.Name())
+			}
+		}
 
 		// for embedded structs, we flatten the fields
 		if underlyingType, isStruct := field.Type().Underlying().(*types.Struct); field.Embedded() && isStruct {
@@ -77,12 +142,20 @@ func extractStructFields(type_ *types.Struct, enums enums.EnumTable) []SQLField
 			continue
 		}
 		goFieldName := field.Name()
+		qualifier := func(pkg *types.Package) string {
+			if pkg == field.Pkg() {
+				return ""
+			}
+			return pkg.Name()
+		}
 		sf := SQLField{
-			GoName:   goFieldName,
-			SQLName:  sqlFieldName,
-			Type:     sqltypes.NewSQLType(field.Type(), enums),
-			Exported: exported,
-			goTag:    reflect.StructTag(type_.Tag(i)),
+			GoName:     goFieldName,
+			SQLName:    sqlFieldName,
+			Type:       sqltypes.NewSQLType(field.Type(), enums).WithTag(reflect.StructTag(type_.Tag(i))),
+			Exported:   exported,
+			goTag:      reflect.StructTag(type_.Tag(i)),
+			GoTypeName: types.TypeString(field.Type(), qualifier),
+			valueType:  types.TypeString(sqltypes.ValueType(field.Type()), qualifier),
 		}
 		out = append(out, sf)
 	}
@@ -102,3 +175,21 @@ func (m *GoSQLTable) SetUniqueColumns(constraints map[string][]string) {
 func (m GoSQLTable) IsColumnUnique(sqlName string) bool {
 	return m.uniqueColumns[sqlName]
 }
+
+// CodecHelpers returns the Go declarations required by the
+// columns of `tables` which database/sql can't handle directly.
+func CodecHelpers(tables []GoSQLTable) string {
+	used := map[sqltypes.Codec]bool{}
+	for _, table := range tables {
+		for _, field := range table.Fields {
+			used[field.Type.Codec] = true
+		}
+	}
+	var out string
+	for _, codec := range [...]sqltypes.Codec{sqltypes.DurationCodec, sqltypes.IPCodec} {
+		if used[codec] {
+			out += codec.Helpers()
+		}
+	}
+	return out
+}
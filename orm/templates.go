@@ -27,6 +27,7 @@ func toLowerFirst(s string) string {
 
 var FnMap = template.FuncMap{
 	"inc":     func(i int) int { return i + 1 },
+	"add":     func(i, j int) int { return i + j },
 	"snake":   toSnakeCase,
 	"varname": toLowerFirst,
 }